@@ -3,31 +3,68 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
-	"strings"
+	"runtime"
 	"time"
 
-	"github.com/aerth/filer"
-	"github.com/drone/drone/cache"
+	"github.com/aerth/thumber/thumber"
 	"github.com/gorilla/mux"
 )
 
 var (
-	timing         = flag.Duration("timing", time.Minute*3, "Interval to reset cache. 0 to disable.")
+	configFile     = flag.String("config", "", "JSON config file of flag names and values. Flags beat THUMBER_* environment variables, which beat the file.")
+	timing         = flag.Duration("timing", time.Minute*3, "How long cache entries live. 0 for until evicted.")
+	cacheSize      = flag.Int64("cache-size", 256<<20, "Memory cache budget in bytes")
 	port           = flag.String("port", "8081", "Port to serve on")
 	netint         = flag.String("bind", "127.0.0.1", "Interface to bind to")
+	listenAddr     = flag.String("listen", "", "Listen here instead of -bind and -port, like unix:/run/thumber.sock or tcp:0.0.0.0:8081. Ignored when socket activated by systemd.")
+	socketPerm     = flag.Int("socket-perm", 0660, "Permissions for a -listen unix: socket")
 	logfile        = flag.String("log", "debug.log", "Log file")
+	accessFile     = flag.String("access-log", "", "Access log file, or stdout. Empty to disable.")
+	accessFormat   = flag.String("access-format", "json", "Access log format: json or combined")
 	uploadsDir     = flag.String("up", "uploads", "Directory to save uploaded files")
-	debug          = flag.Bool("debug", false, "Enable logs")
+	debug          = flag.Bool("debug", false, "Log every request, not just errors and events")
 	noratelimiting = flag.Bool("swamped", false, "Disable rate limiting")
+	rate           = flag.Float64("rate", 1.5, "Rate limit: tokens per second for each IP")
+	burst          = flag.Float64("burst", 15, "Rate limit: most tokens an IP can save up")
+	trustedProxies = flag.String("trusted-proxies", "", "Comma separated CIDRs of reverse proxies to take -proxy-header from (peers on a unix socket always are)")
+	proxyHeader    = flag.String("proxy-header", "X-Forwarded-For", "Header the trusted proxies set the client IP in: X-Forwarded-For, Forwarded or X-Real-IP. Others are ignored, clients can send them too.")
+	ipv6Prefix     = flag.Int("ipv6-prefix", 0, "Rate limit IPv6 clients by prefix, like 64 for one bucket per /64. 0 for single addresses.")
+	costFlag       = flag.String("cost", "", "Rate limit: tokens per request, like upload=5,resize=1,original=1,delete=1")
 	perm           = flag.Int("perm", 0700, "Permissions for uploads directory")
-	maxusers       = flag.Int("max", 1, "Max users at one time")
+	maxusers       = flag.Int("max", 128, "Max requests at one time. Renders are limited by -workers.")
+	workers        = flag.Int("workers", runtime.NumCPU(), "Renders at one time")
+	queueLen       = flag.Int("queue", 64, "Renders waiting for a worker. 503 when full.")
+	queueTimeout   = flag.Duration("queue-timeout", 10*time.Second, "Longest wait for a render worker before 503. 0 for no limit.")
 	filenameLength = flag.Int("len", 6, "File ID length")
+	maxSize        = flag.Int("max-size", 4096, "Largest width or height to resize to")
 	customFormat   = flag.String("custom", "", "Custom formatting."+formathelp)
+	basePath       = flag.String("base-path", "", "Path prefix clients reach the server at, like /img behind a reverse proxy that strips it. Used in links and redirects.")
+	cacheOriginals = flag.String("cache-originals", "public, max-age=31536000", "Cache-Control for original images. Uploads can be deleted, so immutable is a bad idea.")
+	cacheThumbs    = flag.String("cache-thumbs", "public, max-age=31536000", "Cache-Control for thumbnails")
+	cacheHome      = flag.String("cache-home", "no-cache", "Cache-Control for the home page")
+	placeholderImg = flag.String("placeholder", "", "Image file to send <img> requests for missing images")
+	diskcache      = flag.String("diskcache", "", "Directory to cache rendered thumbnails in. Empty to disable.")
+	diskcacheSize  = flag.Int64("diskcache-size", 1<<30, "Disk cache budget in bytes")
+	shard          = flag.Int("shard", 0, "Shard depth for the uploads directory: 2 stores abc123 as ab/c1/abc123. 0 is flat.")
+	migrate        = flag.Bool("migrate", false, "Move a flat uploads directory into -shard subdirectories, then exit. Safe while serving.")
+	storageType    = flag.String("storage", "fs", "Storage backend: fs, mem or s3")
+	s3Endpoint     = flag.String("s3-endpoint", "", "S3 endpoint, like http://localhost:9000 (keys from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY)")
+	s3Bucket       = flag.String("s3-bucket", "thumber", "S3 bucket")
+	s3Region       = flag.String("s3-region", "us-east-1", "S3 region")
+	expire         = flag.Duration("expire", 0, "Default upload TTL, overridden by 'expires' at upload. 0 to keep forever.")
+	sweep          = flag.Duration("sweep", time.Minute, "Interval to delete expired uploads. 0 for only at startup.")
+	tlsCert        = flag.String("tls-cert", "", "Serve HTTPS and HTTP/2 with this certificate file. Reloaded on SIGHUP or when it changes.")
+	tlsKey         = flag.String("tls-key", "", "Key file for -tls-cert")
+	redirectHTTP   = flag.String("redirect-http", "", "With -tls-cert, also listen for plain HTTP on this address, like :80, and redirect it to HTTPS")
+	grace          = flag.Duration("grace", 30*time.Second, "On SIGTERM or SIGINT, how long in-flight requests get to finish")
+	adminAddr      = flag.String("admin", "", "Serve /metrics on this address, like 127.0.0.1:9100, instead of -port")
+	keysFile       = flag.String("keys", "", "JSON file of API keys with their own limits: [{\"name\", \"key\", \"rate\", \"burst\", \"upload_rate\", \"upload_burst\", \"daily_uploads\", \"max_bytes\"}]")
 	version        = "Thumber v1"
 	formathelp     = `
 
@@ -49,7 +86,12 @@ See gorilla/mux HandleFunc formatting for more information.
 	`
 )
 
-var r *mux.Router
+// commit is set at build time, like -ldflags "-X main.commit=abc123".
+// Otherwise it comes from the Go build info, if there is any.
+var commit = ""
+
+// server is what we serve, once settings are loaded
+var server *thumber.Server
 
 func init() {
 
@@ -61,62 +103,94 @@ func init() {
 	flag.Usage = func() {
 		fmt.Println(version)
 		fmt.Println("A thumbnail server")
+		fmt.Println(commandhelp)
 		of()
 	}
 
-	// Format uploadsDir
-	if !strings.Contains(*uploadsDir, "/") {
-		*uploadsDir = "./" + *uploadsDir + "/"
-	}
+}
 
-	// Create the uploadsDir
-	e := os.Mkdir(*uploadsDir, os.FileMode(uint32(*perm)))
-	if e != nil {
-		if !strings.Contains(e.Error(), "exists") {
-			log.Fatalln(e)
-		}
+// Server options from the flags. Storage, Placeholder and AccessLog are
+// left for main, they open files.
+func options() (thumber.Options, error) {
+	opts := thumber.Options{
+		IDLength:       *filenameLength,
+		CustomFormat:   *customFormat,
+		MaxSize:        *maxSize,
+		BasePath:       *basePath,
+		CacheSize:      *cacheSize,
+		CacheTTL:       *timing,
+		DiskCache:      *diskcache,
+		DiskCacheSize:  *diskcacheSize,
+		Rate:           *rate,
+		Burst:          *burst,
+		NoRateLimit:    *noratelimiting,
+		IPv6Prefix:     *ipv6Prefix,
+		ProxyHeader:    *proxyHeader,
+		MaxUsers:       *maxusers,
+		Workers:        *workers,
+		Queue:          *queueLen,
+		QueueTimeout:   *queueTimeout,
+		CacheOriginals: *cacheOriginals,
+		CacheThumbs:    *cacheThumbs,
+		CacheHome:      *cacheHome,
+		Expire:         *expire,
+		Sweep:          *sweep,
+		AccessFormat:   *accessFormat,
+		Metrics:        *adminAddr == "",
+		Version:        version,
+		Commit:         commit,
+		Debug:          *debug,
 	}
-
-	e = filer.Touch(*uploadsDir + "boot")
-	if e != nil {
-		if !strings.Contains(e.Error(), "exists") {
-			log.Fatalln(e)
+	var e error
+	if opts.Costs, e = thumber.ParseCosts(*costFlag); e != nil {
+		return opts, e
+	}
+	if opts.TrustedProxies, e = thumber.ParseTrusted(*trustedProxies); e != nil {
+		return opts, e
+	}
+	if *keysFile != "" {
+		if opts.APIKeys, e = thumber.LoadKeys(*keysFile); e != nil {
+			return opts, e
 		}
 	}
-	// URL routing
-	r = mux.NewRouter()
-
-	r.HandleFunc("/upload", s0Upload).Methods("POST")
+	return opts, nil
+}
 
-	if *customFormat != "" {
-		r.HandleFunc(*customFormat, s0ResizeExt).Methods("GET")
+// Pick a storage backend from flags
+func newStorage() (thumber.Storage, error) {
+	switch *storageType {
+	case "fs", "":
+		return thumber.NewFileStorage(*uploadsDir, *shard, os.FileMode(uint32(*perm)))
+	case "mem":
+		return thumber.NewMemStorage(), nil
+	case "s3":
+		return thumber.NewS3Storage(*s3Endpoint, *s3Bucket, *s3Region,
+			os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	default:
+		return nil, fmt.Errorf("unknown storage %q", *storageType)
 	}
-
-	r.HandleFunc("/{w:[0-9]+}/{h:[0-9]+}/{id}.{ext}", s0ResizeExt).Methods("GET")
-	r.HandleFunc("/{id}.{ext}/{w:[0-9]+}/{h:[0-9]+}", s0ResizeExt).Methods("GET")
-	r.HandleFunc("/{id:[a-zA-Z0-9]{"+strconv.Itoa(*filenameLength)+"}}.{ext:jpg|jpeg|png|gif}",
-		s0Get).Methods("GET")
-	// r.HandleFunc("/{id}.{ext:jpeg}", s0Get).Methods("GET")
-	// r.HandleFunc("/{id}.{ext:gif}", s0Get).Methods("GET")
-	r.HandleFunc("/", s0Home)
-	//r.HandleFunc("/{whatever}", s0Home)
-	//	r.HandleFunc("/{what}.{ever}", s0Home)
-	r.NotFoundHandler = http.HandlerFunc(s0Home)
-	http.Handle("/", r)
-
-	// New Cache
-	c1 = cache.NewTTL(*timing)
-
 }
 
 func main() {
 	flag.Parse()
 
-	if len(flag.Args()) != 0 {
+	// Subcommand, serve if there isn't one
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
 		flag.Usage()
 		os.Exit(2)
 	}
 
+	// Environment and config file, under the flags
+	if e := loadConfig(); e != nil {
+		fmt.Println("Error:", e)
+		os.Exit(2)
+	}
+
 	// Filename + Line numbers
 	if *debug {
 		log.SetFlags(log.Llongfile)
@@ -130,64 +204,152 @@ func main() {
 			panic(e)
 		}
 		log.SetOutput(debuglog)
+		logfiles = append(logfiles, debuglog)
 	}
 
-	// Notify user we are serving
-	go func() {
-		time.Sleep(400 * time.Millisecond)
-		fmt.Printf("Serving on %s:%s\n", *netint, *port) // go func in case port is unavailable
-		fmt.Printf("Logging to %q\n", *logfile)          // go func in case port is unavailable
-		return
-	}()
+	if e := cmd(args); e != nil {
+		fmt.Println("Error:", e)
+		os.Exit(2)
+	}
+}
+
+// thumber serve: the server, until SIGTERM or SIGINT
+func serveCmd(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("serve takes no arguments")
+	}
+	opts, e := options()
+	if e != nil {
+		return e
+	}
+
+	// One line per request
+	if *accessFile == "stdout" {
+		opts.AccessLog = os.Stdout
+	} else if *accessFile != "" {
+		f, e := os.OpenFile(*accessFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if e != nil {
+			return e
+		}
+		opts.AccessLog = f
+		logfiles = append(logfiles, f)
+	}
 
-	// Log requests
-	go logs()
+	// Move flat uploads into shards and exit
+	if *migrate {
+		store, e := newStorage()
+		if e != nil {
+			return e
+		}
+		fs, ok := store.(*thumber.FileStorage)
+		if !ok {
+			return fmt.Errorf("-migrate needs -storage fs")
+		}
+		n, e := fs.Migrate()
+		fmt.Printf("Migrated %d files\n", n)
+		return e
+	}
 
-	// Limit hit per IP per second
-	go ratelimiter()
+	// Caches, render workers, rate limits and the rest
+	server, e = newServer(opts)
+	if e != nil {
+		return e
+	}
+
+	// Metrics on the admin port, or at /metrics
+	if *adminAddr != "" {
+		servers = append(servers, serveAdmin())
+	}
+
+	// Reload settings on SIGHUP
+	go reloader()
 
 	// Serve
-	serve(r)
+	serve(server)
+	return nil
+}
+
+// A Server with opts, on the -storage backend
+func newServer(opts thumber.Options) (*thumber.Server, error) {
+	var e error
+
+	// Where uploads live
+	opts.Storage, e = newStorage()
+	if e != nil {
+		return nil, e
+	}
 
+	// Image for broken <img> tags
+	if *placeholderImg != "" {
+		opts.Placeholder, e = ioutil.ReadFile(*placeholderImg)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return thumber.New(opts)
 }
 
-// Serve route
-func serve(route *mux.Router) {
-	e := http.ListenAndServe(*netint+":"+*port, route)
+// Serve h until SIGTERM or SIGINT, then shut down gracefully
+func serve(h http.Handler) {
+	srv := &http.Server{Addr: *netint + ":" + *port, Handler: h}
+	servers = append(servers, srv)
+
+	// Terminate TLS ourselves
+	if *tlsCert != "" || *tlsKey != "" {
+		certs, e := NewCerts(*tlsCert, *tlsKey)
+		if e != nil {
+			fmt.Println("Error:", e)
+			os.Exit(2)
+		}
+		go certs.Watch()
+		srv.TLSConfig = certs.Config()
+	}
+	done := make(chan struct{})
+	go func() {
+		log.Println("Shutting down:", waitsignal())
+		shutdown(*grace)
+		close(done)
+	}()
+	ln, e := mainListener()
 	if e != nil {
 		fmt.Println("Error:", e)
 		os.Exit(2)
 	}
-}
+	// Send plain HTTP to wherever we really listen, -listen and systemd included
+	if srv.TLSConfig != nil && *redirectHTTP != "" {
+		plain := &http.Server{Addr: *redirectHTTP, Handler: redirectTLS(listenPort(ln))}
+		servers = append(servers, plain)
+		go listen(plain, nil)
+	}
 
-// Limiting struct
-type Limiting struct {
-	Since       time.Time
-	Until       time.Time
-	RateLimited bool
-	Count       int
+	// Notify user we are serving
+	fmt.Printf("Serving on %s://%s\n", ln.Addr().Network(), ln.Addr())
+	fmt.Printf("Logging to %q\n", *logfile)
+	listen(srv, ln)
+	<-done
 }
 
-// Generate random string
-func keygen(n int) string {
-	runes := []rune("abcdefg1234567890123456789012345678901234567890")
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = runes[rand.Intn(len(runes))]
-	}
-	return strings.TrimSpace(string(b))
+func serveAdmin() *http.Server {
+	admin := mux.NewRouter()
+	admin.Handle("/metrics", server.MetricsHandler()).Methods("GET")
+	srv := &http.Server{Addr: *adminAddr, Handler: admin}
+	go listen(srv, nil)
+	return srv
 }
 
-// Make sure keygen is unique file
-func unique() string {
-	id := keygen(*filenameLength)
-	_, er := os.Open(id)
-	if er != nil {
-		if strings.Contains(er.Error(), "no such file or directory") {
-			filer.Touch("./uploads/" + id)
-			return id
-		}
-		log.Println(er)
+// Serve on ln (or srv.Addr if nil) until shut down
+func listen(srv *http.Server, ln net.Listener) {
+	var e error
+	if ln == nil {
+		ln, e = net.Listen("tcp", srv.Addr)
+	}
+	if e == nil && srv.TLSConfig != nil {
+		e = srv.ServeTLS(ln, "", "")
+	} else if e == nil {
+		e = srv.Serve(ln)
+	}
+	if e != nil && e != http.ErrServerClosed {
+		fmt.Println("Error:", e)
+		os.Exit(2)
 	}
-	return unique()
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// servers are stopped together on SIGTERM or SIGINT
+var servers []*http.Server
+
+// logfiles are synced before we exit
+var logfiles []*os.File
+
+// Block until SIGTERM or SIGINT. A second one kills us the usual way.
+func waitsignal() os.Signal {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	s := <-sig
+	signal.Stop(sig)
+	return s
+}
+
+// Fail /readyz, stop accepting connections, and give in-flight requests
+// (uploads and renders) up to grace to finish. Then flush the logs.
+func shutdown(grace time.Duration) {
+	if server != nil {
+		server.Drain()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if e := srv.Shutdown(ctx); e != nil {
+				log.Println("Shutdown:", srv.Addr, e)
+				srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+	log.Println("Shutdown: done")
+	for _, f := range logfiles {
+		f.Sync()
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aerth/thumber/thumber"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	defer func(old []*http.Server) { servers = old }(servers)
+	server, _ = thumber.New(thumber.Options{Storage: thumber.NewMemStorage()})
+	defer func() {
+		server.Close()
+		server = nil
+	}()
+
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond) // a slow upload
+		w.Write([]byte("done"))
+	})}
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, e)
+	go srv.Serve(ln)
+	servers = []*http.Server{srv}
+
+	got := make(chan int)
+	go func() {
+		resp, e := http.Get("http://" + ln.Addr().String())
+		if e != nil {
+			got <- 0
+			return
+		}
+		resp.Body.Close()
+		got <- resp.StatusCode
+	}()
+	<-started
+	shutdown(time.Second)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, 200, <-got)
+
+	// No new connections
+	_, e = http.Get("http://" + ln.Addr().String())
+	assert.NotNil(t, e)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How often to look for a renewed certificate
+var certPoll = 10 * time.Second
+
+// Certs serves a certificate and key pair from disk, reloaded on SIGHUP or
+// when the files change, so renewals don't need a restart.
+type Certs struct {
+	CertFile, KeyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modtime time.Time
+}
+
+// NewCerts loads the pair, or fails if it can't.
+func NewCerts(certFile, keyFile string) (*Certs, error) {
+	c := &Certs{CertFile: certFile, KeyFile: keyFile}
+	return c, c.Reload()
+}
+
+// Reload the pair from disk. The old one is kept if the new one is bad.
+func (c *Certs) Reload() error {
+	mod := c.changed()
+	cert, e := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if e != nil {
+		return e
+	}
+	c.mu.Lock()
+	c.cert, c.modtime = &cert, mod
+	c.mu.Unlock()
+	return nil
+}
+
+// Newest mtime of the pair
+func (c *Certs) changed() time.Time {
+	var newest time.Time
+	for _, name := range []string{c.CertFile, c.KeyFile} {
+		if fi, e := os.Stat(name); e == nil && fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
+	}
+	return newest
+}
+
+// GetCertificate is for tls.Config
+func (c *Certs) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Reload on SIGHUP, or when the files are newer than what we have.
+func (c *Certs) Watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	tick := time.NewTicker(certPoll)
+	defer tick.Stop()
+	for {
+		select {
+		case <-hup:
+		case <-tick.C:
+			c.mu.RLock()
+			same := !c.changed().After(c.modtime)
+			c.mu.RUnlock()
+			if same {
+				continue
+			}
+		}
+		if e := c.Reload(); e != nil {
+			log.Println("TLS: keeping old certificate:", e)
+			continue
+		}
+		log.Println("TLS: reloaded", c.CertFile)
+	}
+}
+
+// TLS with HTTP/2
+func (c *Certs) Config() *tls.Config {
+	return &tls.Config{
+		GetCertificate: c.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// Send plain HTTP to the same place on HTTPS. tlsPort is left off if it's 443.
+func redirectTLS(tlsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, e := net.SplitHostPort(r.Host)
+		if e != nil {
+			host = r.Host
+		}
+		if tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
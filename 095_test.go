@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Write a self-signed certificate for name
+func writeCert(t *testing.T, certFile, keyFile, name string) {
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, e)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, e := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, e)
+	kb, e := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, e)
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+}
+
+func TestCerts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "one.example")
+
+	certs, e := NewCerts(certFile, keyFile)
+	assert.Nil(t, e)
+	name := func() string {
+		c, _ := certs.GetCertificate(nil)
+		leaf, _ := x509.ParseCertificate(c.Certificate[0])
+		return leaf.Subject.CommonName
+	}
+	assert.Equal(t, "one.example", name())
+
+	// A bad file keeps the old certificate
+	ioutil.WriteFile(certFile, []byte("garbage"), 0600)
+	assert.NotNil(t, certs.Reload())
+	assert.Equal(t, "one.example", name())
+
+	writeCert(t, certFile, keyFile, "two.example")
+	assert.Nil(t, certs.Reload())
+	assert.Equal(t, "two.example", name())
+
+	// HTTP/2 over TLS
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	ts.TLS = certs.Config()
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, e := client.Get(ts.URL)
+	if assert.Nil(t, e) {
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "HTTP/2.0", string(b))
+	}
+}
+
+func TestRedirectTLS(t *testing.T) {
+	w := httptest.NewRecorder()
+	redirectTLS("443").ServeHTTP(w, httptest.NewRequest("GET", "http://example.com:80/320/0/abc123.jpg?x=1", nil))
+	assert.Equal(t, 308, w.Code)
+	assert.Equal(t, "https://example.com/320/0/abc123.jpg?x=1", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	redirectTLS("8443").ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/upload", nil))
+	assert.Equal(t, "https://example.com:8443/upload", w.Header().Get("Location"))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The main listener: inherited from systemd, -listen, or -bind and -port.
+func mainListener() (net.Listener, error) {
+	lns, e := systemdListeners()
+	if e != nil {
+		return nil, e
+	}
+	if len(lns) > 0 {
+		for _, extra := range lns[1:] {
+			extra.Close()
+		}
+		return lns[0], nil
+	}
+	addr := *listenAddr
+	if addr == "" {
+		addr = *netint + ":" + *port
+	}
+	return listenOn(addr)
+}
+
+// Listen on "unix:/run/thumber.sock", "tcp:127.0.0.1:8081" or "127.0.0.1:8081"
+func listenOn(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", strings.TrimPrefix(addr, "tcp:"))
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	// A socket left by a crash would make us fail to bind
+	if fi, e := os.Lstat(path); e == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	// Nobody may connect before the chmod below
+	old := umask(0777)
+	ln, e := net.Listen("unix", path)
+	umask(old)
+	if e != nil {
+		return nil, e
+	}
+	if e = os.Chmod(path, os.FileMode(*socketPerm)); e != nil {
+		ln.Close()
+		return nil, e
+	}
+	return ln, nil
+}
+
+// The port ln listens on, or -port for a unix socket
+func listenPort(ln net.Listener) string {
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+		return strconv.Itoa(addr.Port)
+	}
+	return *port
+}
+
+// Listeners passed by systemd socket activation (LISTEN_PID, LISTEN_FDS),
+// starting at fd 3. None if we weren't socket activated.
+func systemdListeners() ([]net.Listener, error) {
+	pid, e := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if e != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, e := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if e != nil || n < 1 {
+		return nil, nil
+	}
+	// Don't pass them on to children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	const first = 3
+	var lns []net.Listener
+	for fd := first; fd < first+n; fd++ {
+		f := os.NewFile(uintptr(fd), "systemd-"+strconv.Itoa(fd))
+		ln, e := net.FileListener(f)
+		f.Close()
+		if e != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("socket activation fd %d: %v", fd, e)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// Set the umask, returning the old one
+func umask(mask int) int {
+	return syscall.Umask(mask)
+}
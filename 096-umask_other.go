@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// No umask here, the chmod after listening has to do
+func umask(mask int) int {
+	return 0
+}
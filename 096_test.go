@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/aerth/thumber/thumber"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sock")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "thumber.sock")
+
+	// Stale socket from a crash
+	old, e := net.Listen("unix", path)
+	assert.Nil(t, e)
+	old.(*net.UnixListener).SetUnlinkOnClose(false)
+	old.Close()
+
+	ln, e := listenOn("unix:" + path)
+	if !assert.Nil(t, e) {
+		return
+	}
+	defer ln.Close()
+	fi, e := os.Stat(path)
+	assert.Nil(t, e)
+	assert.Equal(t, os.FileMode(*socketPerm), fi.Mode().Perm())
+	assert.Equal(t, *port, listenPort(ln))
+
+	srv, _ := thumber.New(thumber.Options{Storage: thumber.NewMemStorage()})
+	defer srv.Close()
+	go http.Serve(ln, srv)
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(_, _ string) (net.Conn, error) { return net.Dial("unix", path) },
+	}}
+	resp, e := client.Get("http://thumber/healthz")
+	if assert.Nil(t, e) {
+		assert.Equal(t, 200, resp.StatusCode)
+		resp.Body.Close()
+	}
+}
+
+func TestListenPort(t *testing.T) {
+	ln, e := listenOn("tcp:127.0.0.1:0")
+	if !assert.Nil(t, e) {
+		return
+	}
+	defer ln.Close()
+	_, want, _ := net.SplitHostPort(ln.Addr().String())
+	assert.NotEqual(t, *port, want)
+	assert.Equal(t, want, listenPort(ln))
+}
+
+func TestSystemdListeners(t *testing.T) {
+	lns, e := systemdListeners()
+	assert.Nil(t, e)
+	assert.Nil(t, lns)
+
+	// Someone else's
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	lns, e = systemdListeners()
+	assert.Nil(t, e)
+	assert.Nil(t, lns)
+}
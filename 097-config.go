@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/aerth/thumber/thumber"
+)
+
+// Settings come from, most important first: flags, THUMBER_* environment
+// variables (THUMBER_CACHE_SIZE for -cache-size), the -config file, and
+// the flag defaults. The config file is a JSON object of flag names:
+//
+//	{"port": "8081", "rate": 2, "burst": 20, "keys": "/etc/thumber/keys.json"}
+//
+// On SIGHUP the environment and file are read again, and the settings in
+// reloadable take effect. The rest need a restart.
+
+// reloadable settings, safe to change while serving
+var reloadable = map[string]bool{
+	"rate": true, "burst": true, "cost": true, "keys": true,
+	"cache-size": true, "timing": true, "diskcache-size": true, "trusted-proxies": true,
+}
+
+// Flags given on the command line, which nothing else overrides
+var cmdline map[string]bool
+
+// Environment variable for a flag
+func envname(flagname string) string {
+	return "THUMBER_" + strings.ToUpper(strings.Replace(flagname, "-", "_", -1))
+}
+
+// Read the config file, if there is one, into flag name -> value
+func readConfig() (map[string]string, error) {
+	path := *configFile
+	if path == "" && !cmdline["config"] {
+		path = os.Getenv(envname("config"))
+	}
+	values := map[string]string{}
+	if path == "" {
+		return values, nil
+	}
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	var raw map[string]interface{}
+	if e = json.Unmarshal(b, &raw); e != nil {
+		return nil, fmt.Errorf("%s: %v", path, e)
+	}
+	for name, v := range raw {
+		if flag.Lookup(name) == nil || name == "config" {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		switch v := v.(type) {
+		case string:
+			values[name] = v
+		case float64, bool:
+			values[name] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("%s: %q should be a string, number or bool", path, name)
+		}
+	}
+	return values, nil
+}
+
+// Where a flag's value comes from, if not the command line
+func configured(f *flag.Flag, file map[string]string) (value, source string) {
+	if v, ok := os.LookupEnv(envname(f.Name)); ok {
+		return v, envname(f.Name)
+	}
+	if v, ok := file[f.Name]; ok {
+		return v, "config " + f.Name
+	}
+	return f.DefValue, ""
+}
+
+// Apply the environment and config file under the flags. Call after
+// flag.Parse, before using any settings.
+func loadConfig() error {
+	cmdline = map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+	file, e := readConfig()
+	if e != nil {
+		return e
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if e != nil || cmdline[f.Name] {
+			return
+		}
+		v, source := configured(f, file)
+		if source == "" {
+			return
+		}
+		if e1 := f.Value.Set(v); e1 != nil {
+			e = fmt.Errorf("%s: %v", source, e1)
+		}
+	})
+	if e != nil {
+		return e
+	}
+	return validate()
+}
+
+// Check settings make sense together
+func validate() error {
+	var bad []string
+	check := func(ok bool, msg string) {
+		if !ok {
+			bad = append(bad, msg)
+		}
+	}
+	check(*rate >= 0 && *burst >= 0, "-rate and -burst can't be negative")
+	check(*maxusers >= 1, "-max must be at least 1")
+	check(*workers >= 1, "-workers must be at least 1")
+	check(*queueLen >= 0, "-queue can't be negative")
+	check(*filenameLength >= 1 && *filenameLength <= 64, "-len must be 1 to 64")
+	check(*cacheSize >= 0 && *diskcacheSize >= 0, "cache sizes can't be negative")
+	check(*shard >= 0 && *shard*2 <= *filenameLength, "-shard can't be negative or longer than -len")
+	check(*ipv6Prefix >= 0 && *ipv6Prefix <= 128, "-ipv6-prefix must be 0 to 128")
+	check(*storageType == "fs" || *storageType == "mem" || *storageType == "s3", "-storage must be fs, mem or s3")
+	check(*accessFormat == "json" || *accessFormat == "combined", "-access-format must be json or combined")
+	check((*tlsCert == "") == (*tlsKey == ""), "-tls-cert and -tls-key go together")
+	if _, e := thumber.ParseCosts(*costFlag); e != nil {
+		bad = append(bad, e.Error())
+	}
+	if _, e := thumber.ParseTrusted(*trustedProxies); e != nil {
+		bad = append(bad, e.Error())
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%s", strings.Join(bad, "; "))
+	}
+	return nil
+}
+
+// v the way f would print it, so "1m" and "1m0s" are the same
+func normal(f *flag.Flag, v string) string {
+	nv, ok := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Value)
+	if !ok || nv.Set(v) != nil {
+		return v
+	}
+	return nv.String()
+}
+
+// Read the environment and config file again and apply the reloadable
+// settings. Nothing changes if anything is wrong.
+func reload() error {
+	file, e := readConfig()
+	if e != nil {
+		return e
+	}
+	old := map[string]string{}
+	var restart []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if e != nil || cmdline[f.Name] || f.Name == "config" {
+			return
+		}
+		v, source := configured(f, file)
+		if !reloadable[f.Name] {
+			if normal(f, v) != f.Value.String() {
+				restart = append(restart, f.Name)
+			}
+			return
+		}
+		old[f.Name] = f.Value.String()
+		if e1 := f.Value.Set(v); e1 != nil {
+			e = fmt.Errorf("%s: %v", source, e1)
+		}
+	})
+	if e == nil {
+		e = validate()
+	}
+	var opts thumber.Options
+	if e == nil {
+		opts, e = options()
+	}
+	if e != nil {
+		for name, v := range old {
+			flag.Set(name, v)
+		}
+		return e
+	}
+	if len(restart) > 0 {
+		sort.Strings(restart)
+		log.Println("Reload: restart to change", strings.Join(restart, ", "))
+	}
+	if server != nil {
+		server.Reload(opts)
+	}
+	log.Println("Reload: done")
+	return nil
+}
+
+// Reload on SIGHUP
+func reloader() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if e := reload(); e != nil {
+			log.Println("Reload: keeping old settings:", e)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aerth/thumber/thumber"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	// Put every setting back after
+	saved := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) { saved[f.Name] = f.Value.String() })
+	defer func() {
+		flag.VisitAll(func(f *flag.Flag) { f.Value.Set(saved[f.Name]) })
+	}()
+
+	dir, _ := ioutil.TempDir("", "config")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "thumber.json")
+	write := func(s string) { ioutil.WriteFile(path, []byte(s), 0600) }
+	*configFile = path
+
+	// Flags beat the environment, which beats the file
+	write(`{"burst": 20, "timing": "2m", "port": "7000", "swamped": true, "rate": 1}`)
+	os.Setenv("THUMBER_BURST", "30")
+	os.Setenv("THUMBER_RATE", "9")
+	defer os.Unsetenv("THUMBER_BURST")
+	defer os.Unsetenv("THUMBER_RATE")
+	flag.Set("rate", "3")
+	assert.Nil(t, loadConfig())
+	assert.Equal(t, 30.0, *burst)
+	assert.Equal(t, 3.0, *rate)
+	assert.Equal(t, 2*time.Minute, *timing)
+	assert.Equal(t, "7000", *port)
+	assert.True(t, *noratelimiting)
+
+	// Validation
+	write(`{"len": 0}`)
+	assert.NotNil(t, loadConfig())
+	write(`{"nope": 1}`)
+	assert.NotNil(t, loadConfig())
+	write(`{"cost": "upload"}`)
+	assert.NotNil(t, loadConfig())
+	os.Setenv("THUMBER_WORKERS", "many")
+	write(`{}`)
+	assert.NotNil(t, loadConfig())
+	os.Unsetenv("THUMBER_WORKERS")
+}
+
+// Spend an upload's cost as ip, and return the RateLimit headers
+func spend(ip string, header ...string) (limit, remaining string, code int) {
+	req := httptest.NewRequest("POST", "/upload", nil)
+	req.RemoteAddr = ip + ":1234"
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w.Header().Get("RateLimit-Limit"), w.Header().Get("RateLimit-Remaining"), w.Code
+}
+
+func TestReload(t *testing.T) {
+	saved := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) { saved[f.Name] = f.Value.String() })
+	opts, _ := options()
+	opts.Storage = thumber.NewMemStorage()
+	server, _ = thumber.New(opts)
+	defer func() {
+		flag.VisitAll(func(f *flag.Flag) { f.Value.Set(saved[f.Name]) })
+		server.Close()
+		server = nil
+	}()
+
+	dir, _ := ioutil.TempDir("", "config")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "thumber.json")
+	keys := filepath.Join(dir, "keys.json")
+	ioutil.WriteFile(keys, []byte(`[{"name": "backend", "key": "s3cret", "max_bytes": 100}]`), 0600)
+	*configFile = path
+	cmdline = map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+
+	_, _, code := spend("192.0.2.9", "X-API-Key", "s3cret") // charged to 192.0.2.9
+	assert.Equal(t, 401, code)
+	ioutil.WriteFile(path, []byte(`{"burst": 40, "cost": "upload=7", "cache-size": 1000, "keys": "`+keys+`"}`), 0600)
+	assert.Nil(t, reload())
+	limit, remaining, _ := spend("192.0.2.1")
+	assert.Equal(t, "40", limit)
+	assert.Equal(t, "33", remaining)
+	assert.Equal(t, int64(1000), *cacheSize)
+	_, _, code = spend("192.0.2.1", "X-API-Key", "s3cret")
+	assert.Equal(t, 400, code)
+
+	// Bad settings change nothing
+	ioutil.WriteFile(path, []byte(`{"burst": -1, "keys": "`+keys+`"}`), 0600)
+	assert.NotNil(t, reload())
+	assert.Equal(t, 40.0, *burst)
+	ioutil.WriteFile(path, []byte(`{"burst": 50, "keys": "`+keys+`"}`), 0600)
+	assert.Nil(t, reload())
+	limit, remaining, _ = spend("192.0.2.2")
+	assert.Equal(t, "50", limit)
+	assert.Equal(t, "45", remaining)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aerth/thumber/thumber"
+)
+
+// Subcommands, after the flags: thumber -up uploads import photos/
+var commands = map[string]func(args []string) error{
+	"serve":  serveCmd,
+	"resize": resizeCmd,
+	"import": importCmd,
+	"gc":     gcCmd,
+	"stats":  statsCmd,
+}
+
+var commandhelp = `
+Usage: thumber [flags] [command]
+
+	serve                          serve (the default)
+	resize WxH.ext outdir paths... resize files, or directories of them, like a request for /W/H/id.ext would
+	import paths...                add files, or directories of them, to -storage and print "id token path" for each
+	gc                             delete expired uploads
+	stats                          print what is stored, as JSON
+`
+
+// Where commands print. Errors go to stderr.
+var stdout io.Writer = os.Stdout
+
+// A file to work on, and its path relative to the directory it was found in
+type file struct {
+	path, rel string
+}
+
+// Files named in paths, and the files under directories in paths.
+// Hidden files are skipped.
+func walk(paths []string) ([]file, error) {
+	var files []file
+	for _, p := range paths {
+		fi, e := os.Stat(p)
+		if e != nil {
+			return nil, e
+		}
+		if !fi.IsDir() {
+			files = append(files, file{p, filepath.Base(p)})
+			continue
+		}
+		e = filepath.Walk(p, func(path string, fi os.FileInfo, e error) error {
+			if e != nil || fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+				return e
+			}
+			rel, e := filepath.Rel(p, path)
+			files = append(files, file{path, rel})
+			return e
+		})
+		if e != nil {
+			return nil, e
+		}
+	}
+	return files, nil
+}
+
+// Read f, or nil if it isn't an image
+func readImage(f file) ([]byte, error) {
+	b, e := ioutil.ReadFile(f.path)
+	if e != nil || strings.HasPrefix(http.DetectContentType(b), "image/") {
+		return b, e
+	}
+	fmt.Fprintln(os.Stderr, "Skipping", f.path+": not an image")
+	return nil, nil
+}
+
+// A Server for commands that don't serve, that won't sweep on its own
+func offlineServer() (*thumber.Server, error) {
+	opts, e := options()
+	if e != nil {
+		return nil, e
+	}
+	opts.Sweep = -1
+	return newServer(opts)
+}
+
+// thumber resize 320x0.jpeg thumbs/ photos/: the same bytes the server
+// would send for /320/0/id.jpeg, on -workers at once.
+func resizeCmd(args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: thumber resize WxH.ext outdir paths...")
+	}
+	var w, h int
+	var ext string
+	if n, _ := fmt.Sscanf(strings.Replace(args[0], ".", " ", 1), "%dx%d %s", &w, &h, &ext); n != 3 {
+		return fmt.Errorf("bad size %q, want something like 320x0.jpeg", args[0])
+	}
+	ext = strings.ToLower(ext)
+	if e := (thumber.Transform{Width: w, Height: h, Ext: ext}).Check(*maxSize); e != nil {
+		return e
+	}
+	outdir := args[1]
+	files, e := walk(args[2:])
+	if e != nil {
+		return e
+	}
+
+	jobs := make(chan file)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed int
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				out := filepath.Join(outdir, strings.TrimSuffix(f.rel, filepath.Ext(f.rel))+"."+ext)
+				if e := resizeFile(f, out, w, h, ext); e != nil {
+					fmt.Fprintln(os.Stderr, f.path+":", e)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, f := range files {
+		jobs <- f
+	}
+	close(jobs)
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(files))
+	}
+	return nil
+}
+
+func resizeFile(f file, out string, w, h int, ext string) error {
+	src, e := readImage(f)
+	if src == nil || e != nil {
+		return e
+	}
+	b, e := thumber.Resize(src, w, h, ext)
+	if e != nil {
+		return e
+	}
+	if e = os.MkdirAll(filepath.Dir(out), 0755); e != nil {
+		return e
+	}
+	if e = ioutil.WriteFile(out, b, 0644); e != nil {
+		return e
+	}
+	fmt.Fprintln(stdout, out)
+	return nil
+}
+
+// thumber import photos/: upload every image, expiring after -expire
+func importCmd(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: thumber import paths...")
+	}
+	files, e := walk(args)
+	if e != nil {
+		return e
+	}
+	srv, e := offlineServer()
+	if e != nil {
+		return e
+	}
+	defer srv.Close()
+	for _, f := range files {
+		b, e := readImage(f)
+		if e != nil {
+			return e
+		}
+		if b == nil {
+			continue
+		}
+		id, token, e := srv.Upload(b, *expire)
+		if e != nil {
+			return e
+		}
+		fmt.Fprintf(stdout, "%s %s %s\n", id, token, f.path)
+	}
+	return nil
+}
+
+// thumber gc: delete expired uploads now
+func gcCmd(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("gc takes no arguments")
+	}
+	srv, e := offlineServer()
+	if e != nil {
+		return e
+	}
+	defer srv.Close()
+	n, e := srv.Sweep()
+	if e != nil {
+		return e
+	}
+	fmt.Fprintf(stdout, "Deleted %d expired uploads\n", n)
+	return nil
+}
+
+// thumber stats: what's in storage and the disk cache
+func statsCmd(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("stats takes no arguments")
+	}
+	srv, e := offlineServer()
+	if e != nil {
+		return e
+	}
+	defer srv.Close()
+	st, e := srv.Stats()
+	if e != nil {
+		return e
+	}
+	b, _ := json.MarshalIndent(st, "", "  ")
+	fmt.Fprintln(stdout, string(b))
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aerth/thumber/thumber"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommands(t *testing.T) {
+	saved := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) { saved[f.Name] = f.Value.String() })
+	var out bytes.Buffer
+	stdout = &out
+	defer func() {
+		flag.VisitAll(func(f *flag.Flag) { f.Value.Set(saved[f.Name]) })
+		stdout = os.Stdout
+	}()
+	dir, _ := ioutil.TempDir("", "commands")
+	defer os.RemoveAll(dir)
+	*uploadsDir = filepath.Join(dir, "uploads")
+	*storageType = "fs"
+
+	in := filepath.Join(dir, "in")
+	os.MkdirAll(filepath.Join(in, "sub"), 0700)
+	b, _ := ioutil.ReadFile("thumber/testdata/one.jpeg")
+	ioutil.WriteFile(filepath.Join(in, "sub", "one.jpeg"), b, 0600)
+	ioutil.WriteFile(filepath.Join(in, "notes.txt"), []byte("hello"), 0600)
+
+	// Import
+	assert.Nil(t, importCmd([]string{in}))
+	fields := strings.Fields(out.String())
+	if !assert.Len(t, fields, 3) {
+		return
+	}
+	id := fields[0]
+
+	// Offline resizes are what the server sends
+	out.Reset()
+	assert.Nil(t, resizeCmd([]string{"32x0.png", filepath.Join(dir, "out"), in}))
+	assert.Equal(t, filepath.Join(dir, "out", "sub", "one.png")+"\n", out.String())
+	resized, _ := ioutil.ReadFile(filepath.Join(dir, "out", "sub", "one.png"))
+	srv, e := offlineServer()
+	if !assert.Nil(t, e) {
+		return
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/32/0/"+id+".png", nil))
+	srv.Close()
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, w.Body.Bytes(), resized)
+	assert.NotNil(t, resizeCmd([]string{"32x0.bmp", dir, in}))
+	assert.NotNil(t, resizeCmd([]string{"0x0.png", dir, in}))
+	assert.NotNil(t, resizeCmd([]string{"100000x100000.png", dir, in}))
+
+	// Again, expiring
+	*expire = time.Millisecond
+	out.Reset()
+	assert.Nil(t, importCmd([]string{in}))
+	expiring := strings.Fields(out.String())[0]
+
+	// Stats, then gc
+	time.Sleep(5 * time.Millisecond)
+	out.Reset()
+	assert.Nil(t, statsCmd(nil))
+	var st thumber.Stats
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &st))
+	assert.Equal(t, 2, st.Uploads)
+	assert.Equal(t, int64(2*len(b)), st.Bytes)
+	assert.Equal(t, 1, st.Expired)
+	out.Reset()
+	assert.Nil(t, gcCmd(nil))
+	assert.Equal(t, "Deleted 1 expired uploads\n", out.String())
+	_, e = os.Stat(filepath.Join(*uploadsDir, expiring))
+	assert.True(t, os.IsNotExist(e))
+	_, e = os.Stat(filepath.Join(*uploadsDir, id))
+	assert.Nil(t, e)
+}
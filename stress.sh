@@ -25,10 +25,10 @@ homeTest() {
 done
 }
 
-# Upload testdata/one.jpeg
+# Upload thumber/testdata/one.jpeg
 uploadTest() {
   for i in {1..3200}; do
-curl --form file=@testdata/one.jpeg localhost:8081/upload -v
+curl --form file=@thumber/testdata/one.jpeg localhost:8081/upload -v
 done
 # then something like
 # for i in $(ls); do diff __pickone__ $i; done
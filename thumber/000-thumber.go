@@ -0,0 +1,285 @@
+// Package thumber is a thumbnailing server: upload images, then get them back
+// resized by URL, like /320/240/abc123.jpg. Mount a Server in any mux, or
+// build the thumber command in the parent directory for the standalone server.
+package thumber
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Options configure a Server. Start from DefaultOptions.
+type Options struct {
+	Storage      Storage // where uploads live, a MemStorage if nil
+	IDLength     int     // length of upload IDs
+	CustomFormat string  // another resize route, like /thumb/{w:[0-9]+}/{h:[0-9]+}/{id}.{ext}
+	MaxSize      int     // largest width or height to resize to, 4096 if 0
+	BasePath     string  // where s is mounted, like /img with http.StripPrefix, for its links and redirects
+
+	CacheSize     int64         // memory cache budget in bytes
+	CacheTTL      time.Duration // how long memory cache entries live, 0 for until evicted
+	DiskCache     string        // directory to cache renders in, empty for none
+	DiskCacheSize int64         // disk cache budget in bytes
+
+	Rate           float64            // rate limit: tokens per second for each IP
+	Burst          float64            // rate limit: most tokens an IP can save up
+	Costs          map[string]float64 // tokens per request by route name, over the defaults
+	NoRateLimit    bool               // don't rate limit at all
+	TrustedProxies []*net.IPNet       // believe ProxyHeader from these
+	ProxyHeader    string             // what they set: X-Forwarded-For (if empty), Forwarded or X-Real-IP
+	IPv6Prefix     int                // rate limit IPv6 clients by prefix, 0 for single addresses
+	APIKeys        []*APIKey          // clients with their own limits, see LoadKeys
+
+	MaxUsers     int           // requests at one time
+	Workers      int           // renders at one time
+	Queue        int           // renders waiting for a worker
+	QueueTimeout time.Duration // longest wait for a worker, 0 for no limit
+
+	CacheOriginals string // Cache-Control for original images
+	CacheThumbs    string // Cache-Control for thumbnails
+	CacheHome      string // Cache-Control for the home page
+	Placeholder    []byte // image for <img> requests that fail, nil for none
+
+	Expire time.Duration // default upload TTL, 0 to keep forever
+	Sweep  time.Duration // how often to delete expired uploads, 0 for only at startup, negative for never
+
+	AccessLog    io.Writer   // a line per request, nil for none
+	AccessFormat string      // json or combined
+	Metrics      bool        // serve /metrics, see also MetricsHandler
+	Logger       *log.Logger // errors and events, and with Debug each request; the log package's if nil
+
+	Version string // for /version
+	Commit  string
+	Debug   bool // log every request to Logger, not just errors and events
+}
+
+// DefaultOptions are the thumber binary's defaults, in memory.
+func DefaultOptions() Options {
+	return Options{
+		IDLength:       6,
+		MaxSize:        4096,
+		CacheSize:      256 << 20,
+		CacheTTL:       3 * time.Minute,
+		DiskCacheSize:  1 << 30,
+		Rate:           1.5,
+		Burst:          15,
+		MaxUsers:       128,
+		Workers:        runtime.NumCPU(),
+		Queue:          64,
+		QueueTimeout:   10 * time.Second,
+		CacheOriginals: "public, max-age=31536000",
+		CacheThumbs:    "public, max-age=31536000",
+		CacheHome:      "no-cache",
+		Sweep:          time.Minute,
+		AccessFormat:   "json",
+		Version:        "Thumber v1",
+	}
+}
+
+// Server serves uploads and thumbnails. It is an http.Handler.
+type Server struct {
+	opts    Options
+	router  *mux.Router
+	store   Storage
+	c1      *MemCache  // rendered thumbnails, originals and metadata
+	c2      *DiskCache // rendered thumbnails on disk, nil if off
+	limiter *Limiter
+	pool    *Pool
+	renders *Flight
+	metrics *metrics
+	logger  *log.Logger
+
+	// Swapped by Reload
+	confmu  sync.RWMutex
+	costs   map[string]float64
+	trusted []*net.IPNet
+	apikeys []*APIKey
+
+	expiries map[string]time.Time // see setexpiry
+	pending  map[string]time.Time // the ones not swept yet
+	scanned  time.Time            // when Sweep last read all the metadata
+	emutex   sync.Mutex
+	deleted  map[string]time.Time // see bury
+	dmutex   sync.Mutex
+
+	logchan   chan *http.Request // HandleFuncs can send req to this chan to log it.
+	ratelimit chan Hit           // Global max users at one time
+	accessMu  sync.Mutex
+	stopping  int32
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a Server, and starts its housekeeping. Close stops it.
+func New(opts Options) (*Server, error) {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	s := &Server{
+		opts:     opts,
+		store:    opts.Storage,
+		c1:       NewMemCache(opts.CacheSize, opts.CacheTTL),
+		limiter:  NewLimiter(opts.Rate, opts.Burst),
+		pool:     NewPool(opts.Workers, opts.Queue, opts.QueueTimeout),
+		renders:  new(Flight),
+		metrics:  newMetrics(),
+		logger:   opts.Logger,
+		trusted:  opts.TrustedProxies,
+		apikeys:  opts.APIKeys,
+		expiries: map[string]time.Time{},
+		pending:  map[string]time.Time{},
+		deleted:  map[string]time.Time{},
+		done:     make(chan struct{}),
+	}
+	if s.store == nil {
+		s.store = NewMemStorage()
+	}
+	if !proxyHeaders[http.CanonicalHeaderKey(opts.ProxyHeader)] {
+		return nil, fmt.Errorf("bad proxy header %q, want X-Forwarded-For, Forwarded or X-Real-IP", opts.ProxyHeader)
+	}
+	if s.logger == nil {
+		s.logger = log.Default()
+	}
+	if s.opts.MaxUsers < 1 {
+		s.opts.MaxUsers = 1
+	}
+	if s.opts.MaxSize < 1 {
+		s.opts.MaxSize = 4096
+	}
+	s.opts.BasePath = strings.TrimSuffix(s.opts.BasePath, "/")
+	if s.opts.BasePath != "" && !strings.HasPrefix(s.opts.BasePath, "/") {
+		s.opts.BasePath = "/" + s.opts.BasePath
+	}
+	if s.opts.IDLength < 1 {
+		s.opts.IDLength = 6
+	}
+	s.costs = withCosts(opts.Costs)
+	for _, k := range s.apikeys {
+		if k.requests == nil && k.uploads == nil {
+			k.init()
+		}
+	}
+	if opts.DiskCache != "" {
+		var e error
+		if s.c2, e = NewDiskCache(opts.DiskCache, opts.DiskCacheSize); e != nil {
+			return nil, e
+		}
+	}
+	s.logchan = make(chan *http.Request, s.opts.MaxUsers)
+	s.ratelimit = make(chan Hit, s.opts.MaxUsers)
+	s.router = s.routes()
+
+	// What API keys have used so far
+	s.countUsage()
+
+	// Log requests
+	go s.logs()
+
+	// Forget idle clients
+	go s.ratelimiter()
+
+	// Delete expired uploads
+	go s.sweeper()
+	return s, nil
+}
+
+// Close stops the housekeeping. It doesn't stop anyone serving s.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// Sleep for d, or return false if s is closed
+func (s *Server) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// ServeHTTP serves the home page, uploads, originals and thumbnails.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.accesslog(s.router).ServeHTTP(w, r)
+}
+
+// Drain makes /readyz fail, so load balancers stop sending traffic
+// before a shutdown. s keeps serving.
+func (s *Server) Drain() {
+	atomic.StoreInt32(&s.stopping, 1)
+}
+
+// Reload applies the settings that can change while serving: Rate, Burst,
+// Costs, TrustedProxies, APIKeys, CacheSize, CacheTTL and DiskCacheSize.
+// The rest of opts is ignored. API keys keep what they've used so far.
+func (s *Server) Reload(opts Options) {
+	s.limiter.SetRate(opts.Rate, opts.Burst)
+	s.c1.Resize(opts.CacheSize, opts.CacheTTL)
+	if s.c2 != nil {
+		s.c2.Resize(opts.DiskCacheSize)
+	}
+	for _, k := range opts.APIKeys {
+		if k.requests == nil && k.uploads == nil {
+			k.init()
+		}
+	}
+	s.confmu.Lock()
+	s.costs = withCosts(opts.Costs)
+	s.trusted = opts.TrustedProxies
+	s.apikeys = carryUsage(s.apikeys, opts.APIKeys)
+	s.confmu.Unlock()
+}
+
+// A path on s, as the client sees it
+func (s *Server) url(path string) string {
+	return s.opts.BasePath + path
+}
+
+// Storage is where s keeps uploads
+func (s *Server) Storage() Storage {
+	return s.store
+}
+
+// URL routing
+func (s *Server) routes() *mux.Router {
+	r := mux.NewRouter()
+	idlen := strconv.Itoa(s.opts.IDLength)
+
+	r.HandleFunc("/upload", s.s0Upload).Methods("POST").Name("upload")
+	r.HandleFunc("/delete", s.s0DeleteForm).Methods("POST").Name("delete")
+	r.HandleFunc("/{id:[a-zA-Z0-9]{"+idlen+"}}", s.s0Delete).Methods("DELETE").Name("delete")
+	r.HandleFunc("/{id:[a-zA-Z0-9]{"+idlen+"}}.{ext}", s.s0Delete).Methods("DELETE").Name("delete")
+
+	if s.opts.CustomFormat != "" {
+		r.HandleFunc(s.opts.CustomFormat, s.s0ResizeExt).Methods("GET", "HEAD").Name("resize")
+	}
+
+	r.HandleFunc("/{w:[0-9]+}/{h:[0-9]+}/{id}.{ext}", s.s0ResizeExt).Methods("GET", "HEAD").Name("resize")
+	r.HandleFunc("/{id}.{ext}/{w:[0-9]+}/{h:[0-9]+}", s.s0ResizeExt).Methods("GET", "HEAD").Name("resize")
+	r.HandleFunc("/{id:[a-zA-Z0-9]{"+idlen+"}}.{ext:(?i:jpg|jpeg|png|gif)}",
+		s.s0Get).Methods("GET", "HEAD").Name("original")
+	r.HandleFunc("/healthz", s.s0Healthz).Methods("GET", "HEAD").Name("healthz")
+	r.HandleFunc("/readyz", s.s0Readyz).Methods("GET", "HEAD").Name("readyz")
+	r.HandleFunc("/version", s.s0Version).Methods("GET", "HEAD").Name("version")
+	if s.opts.Metrics {
+		r.Handle("/metrics", s.MetricsHandler()).Methods("GET").Name("metrics")
+	}
+	r.HandleFunc("/", s.s0Home).Name("home")
+	r.NotFoundHandler = s.instrument(s.limit(http.HandlerFunc(s.s0NotFound)))
+	r.Use(s.instrument, s.limit)
+	return r
+}
@@ -0,0 +1,728 @@
+package thumber
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+Test that:
+⎈ home page gets up, testserver can start
+⎈ invalid requests get trashed
+valid requests are valid
+valid requests get cached
+cache gets invalidated after x
+limit kicks in after 20 req / 10 sec
+
+limiter rules:
+upload is scored as 5 req
+view/resize is 1 req
+cached is 0 req
+
+make sure this is valid:
+  upload 2 files, view 2 files, resize 2 files in ten seconds
+  (total 6 clicks, 14 reqs ,under 20)
+
+*/
+
+// newTestServer returns a Server of its own for one test, uploading to a
+// temporary directory, with DefaultOptions changed by change if it isn't nil.
+// It is closed when the test ends.
+func newTestServer(t *testing.T, change func(*Options)) *Server {
+	dir, e := ioutil.TempDir("", "thumber")
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	opts := DefaultOptions()
+	if opts.Storage, e = NewFileStorage(dir, 0, 0700); e != nil {
+		t.Fatal(e)
+	}
+	opts.Metrics = true
+	if change != nil {
+		change(&opts)
+	}
+	s, e := New(opts)
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// GET path from s, with header names and values
+func get(s *Server, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+// Wait a few seconds at most for ok, instead of sleeping and hoping
+func waitFor(t *testing.T, ok func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !ok(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
+
+// Log lines, safe to read while the server is still writing them
+type logbuf struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *logbuf) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *logbuf) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.String()
+}
+
+// Options logging to buf, and every request too with debug
+func logTo(buf *logbuf, debug bool) func(*Options) {
+	return func(o *Options) {
+		o.Logger = log.New(buf, "", log.Lshortfile)
+		o.Debug = debug
+	}
+}
+
+func init() {
+	log.SetFlags(log.Lshortfile)
+	log.SetPrefix("")
+}
+
+func TestHome(t *testing.T) {
+	ts := httptest.NewServer(newTestServer(t, nil))
+	defer ts.Close()
+
+	rs, e := http.Get(ts.URL)
+	if e != nil {
+		t.FailNow()
+		fmt.Println(e)
+	}
+	resp, _ := ioutil.ReadAll(rs.Body)
+	rs.Body.Close()
+	//fmt.Println(string(resp))
+
+	if string(resp) != homegold {
+		t.Fail()
+		fmt.Println("Got:", string(resp))
+		fmt.Println("Wanted:", homegold)
+	}
+}
+func TestNotFound(t *testing.T) {
+	srv := newTestServer(t, func(o *Options) { o.Placeholder, _ = ioutil.ReadFile("testdata/one.jpeg") })
+	invalidgroup := []string{"/longlength.png", "/short.png", "/abc12.jpg", "/abc123.JPG", "/index.php", "/⚛",
+		"/phpMyAdmin/index.php", "/somethingrandom", "/funny.js", "/1/2/3"}
+	for _, testcase := range invalidgroup {
+		w := get(srv, testcase)
+		assert.Equal(t, 404, w.Code, testcase)
+		assert.True(t, strings.Contains(w.Body.String(), "404 Not Found"), testcase)
+	}
+
+	// <img> tags get the placeholder, not a page
+	w := get(srv, "/short.png", "Sec-Fetch-Dest", "image")
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, srv.opts.Placeholder, w.Body.Bytes())
+}
+func TestBadForm(t *testing.T) {
+	logbuf := new(logbuf)
+	srv := newTestServer(t, logTo(logbuf, false))
+
+	// Form data is a buffer
+	var formdata = new(bytes.Buffer)
+	formdata.WriteString("hello")
+	req := httptest.NewRequest("POST", "/upload", ioutil.NopCloser(formdata))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	found := w.Header().Get("Location")
+	if w.Code != 400 || found != "" || !strings.Contains(w.Body.String(), "bad multipart form") || !strings.Contains(logbuf.String(), "Bad multipart form.") {
+		fmt.Println("Found:", found)
+		fmt.Println("Body:", w.Body.String())
+		fmt.Println(w.Code)
+		fmt.Println("Log:", logbuf.String())
+		t.Fail()
+	}
+}
+func TestImgUpload(t *testing.T) {
+	imgUpload(t, newTestServer(t, nil))
+}
+
+// Upload testdata/wu.jpg to srv, follow the redirect and check the original
+// comes back. Returns its path, or "" if limited.
+func imgUpload(t *testing.T, srv *Server) string {
+	filename := "testdata/wu.jpg"
+
+	// load the filebytes
+	picbuf, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+
+	// request body
+	body := new(bytes.Buffer)
+
+	// multipart writer formats the body
+	ww := multipart.NewWriter(body)
+	formWriter, err := ww.CreateFormFile("file", "null.jpg")
+	assert.Nil(t, err)
+	formWriter.Write(picbuf)
+	if err = ww.Close(); err != nil {
+		t.Error(err)
+		return ""
+	}
+
+	// Create request
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Add("Content-Type", ww.FormDataContentType())
+
+	// Tester (r is mux Router)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	// Check response
+	// (Should be something like: /320/0/d15454.jpg) where d15454 is the generated ID
+	found := w.Header().Get("Location")
+	fmt.Println("[TestImgUpload] Found:", found)
+	slash := strings.Split(strings.TrimPrefix(found, "/"), "/")
+
+	if w.Code == 429 {
+		fmt.Println("[TestImgUpload] Limited")
+		return ""
+	}
+	if !assert.Len(t, slash, 3) {
+		return ""
+	}
+	assert.Equal(t, "320", slash[0])
+	assert.Equal(t, slash[1], "0")
+	assert.True(t, strings.HasSuffix(slash[2], ".jpg"))
+	newtarget := "/" + slash[2]
+
+	// Try the get the image
+	fmt.Println("[TestImgUpload] Trying: ", newtarget)
+	bb := get(srv, newtarget).Body.Bytes()
+
+	// Test lengths
+	assert.Equal(t, len(picbuf), len(bb))
+
+	// Test bytes.Equal
+	if !bytes.Equal(picbuf, bb) {
+		t.Fail()
+	}
+	if !t.Failed() {
+		fmt.Println("[TestImgUpload] Success!", newtarget)
+	}
+	return newtarget
+}
+
+// Upload testdata/one.jpeg to srv with form fields, asking for JSON. Fails
+// the test if the upload does.
+func uploadJSON(t *testing.T, srv *Server, fields map[string]string) map[string]string {
+	picbuf, err := ioutil.ReadFile("testdata/one.jpeg")
+	assert.Nil(t, err)
+	body := new(bytes.Buffer)
+	ww := multipart.NewWriter(body)
+	for k, v := range fields {
+		ww.WriteField(k, v)
+	}
+	formWriter, err := ww.CreateFormFile("file", "null.jpg")
+	assert.Nil(t, err)
+	formWriter.Write(picbuf)
+	assert.Nil(t, ww.Close())
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Add("Content-Type", ww.FormDataContentType())
+	req.Header.Add("Accept", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("upload: %d %s", w.Code, w.Body)
+	}
+	var up map[string]string
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &up))
+	assert.Equal(t, w.Header().Get("X-Delete-Token"), up["delete"])
+	return up
+}
+
+func TestBasePath(t *testing.T) {
+	srv := newTestServer(t, func(o *Options) { o.BasePath = "img/" })
+	mux := http.NewServeMux()
+	mux.Handle("/img/", http.StripPrefix("/img", srv))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	up := uploadJSON(t, srv, nil)
+	assert.Equal(t, "/img/"+up["id"]+".jpg", up["url"])
+	assert.Equal(t, "/img/320/0/"+up["id"]+".jpg", up["thumb"])
+	assert.Equal(t, 200, serve("GET", up["url"]).Code)
+	assert.Equal(t, 200, serve("GET", up["thumb"]).Code)
+
+	w := serve("GET", "/img/")
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `action="/img/upload"`))
+	w = serve("GET", "/img/nope/")
+	assert.Equal(t, 404, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `<a href="/img/">`))
+	assert.Equal(t, "/img/?bad", serve("POST", "/img/delete").Header().Get("Location"))
+}
+
+func TestImgExpired(t *testing.T) {
+	srv := newTestServer(t, nil)
+	up := uploadJSON(t, srv, map[string]string{"expires": "1ms"})
+	assert.NotEmpty(t, up["expires"])
+	time.Sleep(10 * time.Millisecond)
+
+	for _, path := range []string{up["url"], up["thumb"]} {
+		assert.Equal(t, 410, get(srv, path).Code, path)
+	}
+
+	// Sweeper deletes the file, the ID stays gone
+	srv.Sweep()
+	_, err := srv.store.Stat(up["id"])
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 410, get(srv, up["url"]).Code)
+}
+
+// Expired before this Server ever swept: after a restart, or on another
+// instance sharing the storage.
+func TestImgExpiredElsewhere(t *testing.T) {
+	b, _ := ioutil.ReadFile("testdata/one.jpeg")
+	store := NewMemStorage()
+	first := newTestServer(t, func(o *Options) { o.Storage = store })
+	id, _, e := first.Upload(b, time.Millisecond)
+	first.Close()
+	assert.Nil(t, e)
+	time.Sleep(5 * time.Millisecond)
+
+	second := newTestServer(t, func(o *Options) { o.Storage, o.Sweep = store, -1 })
+	for _, path := range []string{"/" + id + ".jpg", "/32/0/" + id + ".jpg"} {
+		assert.Equal(t, 410, get(second, path).Code, path)
+	}
+	_, e = second.Render(Transform{ID: id, Width: 32, Ext: "jpg"})
+	assert.Equal(t, errNotFound, e)
+
+	// Sweep 0 still sweeps once, at startup
+	newTestServer(t, func(o *Options) { o.Storage, o.Sweep = store, 0 })
+	waitFor(t, func() bool {
+		_, e := store.Stat(id)
+		return e != nil
+	})
+}
+
+// Storage counting its Gets
+type countingStorage struct {
+	Storage
+	mu   sync.Mutex
+	gets int
+}
+
+func (c *countingStorage) Get(key string) ([]byte, error) {
+	c.mu.Lock()
+	c.gets++
+	c.mu.Unlock()
+	return c.Storage.Get(key)
+}
+
+func (c *countingStorage) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.gets
+	c.gets = 0
+	return n
+}
+
+// Sweeps read every upload's metadata only now and then, and don't cache it
+func TestSweepIndex(t *testing.T) {
+	b, _ := ioutil.ReadFile("testdata/one.jpeg")
+	store := &countingStorage{Storage: NewMemStorage()}
+	first := newTestServer(t, func(o *Options) { o.Storage, o.Sweep = store, -1 })
+	for i := 0; i < 10; i++ {
+		first.Upload(b, 0)
+	}
+	first.Upload(b, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	srv := newTestServer(t, func(o *Options) { o.Storage, o.Sweep = store, -1 })
+	store.count()
+	n, e := srv.Sweep()
+	assert.Nil(t, e)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 11, store.count())
+	assert.Equal(t, 0, srv.c1.Stats().Items)
+
+	// After that, only what this Server knows expires
+	srv.Upload(b, time.Millisecond)
+	srv.Upload(b, time.Hour)
+	time.Sleep(5 * time.Millisecond)
+	store.count()
+	n, _ = srv.Sweep()
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, store.count())
+	n, _ = srv.Sweep()
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 0, store.count())
+}
+
+func TestImgDelete(t *testing.T) {
+	srv := newTestServer(t, nil)
+	up := uploadJSON(t, srv, nil)
+	assert.NotEmpty(t, up["delete"])
+
+	// Hash in storage, not the token
+	meta, _ := srv.store.Get(up["id"] + ".meta")
+	assert.False(t, strings.Contains(string(meta), up["delete"]))
+
+	// Wrong token
+	req := httptest.NewRequest("DELETE", "/"+up["id"], nil)
+	req.Header.Set("X-Delete-Token", "nope")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, 403, w.Code)
+
+	// Right token
+	req = httptest.NewRequest("DELETE", "/"+up["id"], nil)
+	req.Header.Set("X-Delete-Token", up["delete"])
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, 204, w.Code)
+	_, err := srv.store.Stat(up["id"])
+	assert.True(t, os.IsNotExist(err))
+	_, err = srv.store.Stat(up["id"] + ".meta")
+	assert.True(t, os.IsNotExist(err))
+
+	// Gone
+	req = httptest.NewRequest("DELETE", "/"+up["id"], nil)
+	req.Header.Set("X-Delete-Token", up["delete"])
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+// A render finishing after its upload was deleted doesn't bring it back
+func TestDeleteDuringRender(t *testing.T) {
+	b, _ := ioutil.ReadFile("testdata/one.jpeg")
+	dir, _ := ioutil.TempDir("", "diskcache")
+	defer os.RemoveAll(dir)
+	s := newTestServer(t, func(o *Options) { o.DiskCache = dir })
+	id, token, _ := s.Upload(b, 0)
+	tr := Transform{ID: id, Width: 32, Ext: "jpg"}
+	thumb, e := s.Render(tr)
+	assert.Nil(t, e)
+
+	assert.Nil(t, s.Delete(id, token))
+	s.cache(id, tr.Key(), thumb, true) // the render, late
+	_, ok := s.c1.Get(tr.Key())
+	assert.False(t, ok)
+	_, ok = s.c2.Get(tr.Key())
+	assert.False(t, ok)
+	for _, path := range []string{"/" + id + ".jpg", "/32/0/" + id + ".jpg"} {
+		assert.Equal(t, 404, get(s, path).Code, path)
+	}
+	_, e = s.Render(tr)
+	assert.Equal(t, errNotFound, e)
+}
+
+func TestImgCached(t *testing.T) {
+	logbuf := new(logbuf)
+	srv := newTestServer(t, logTo(logbuf, true))
+	goodImageURL := imgUpload(t, srv)
+	bb := get(srv, goodImageURL).Body.Bytes()
+	assert.True(t, strings.Contains(logbuf.String(), "is cached."))
+	b, _ := ioutil.ReadFile("testdata/wu.jpg")
+	assert.Equal(t, b, bb)
+}
+
+func TestQuietLog(t *testing.T) {
+	logbuf := new(logbuf)
+	srv := newTestServer(t, logTo(logbuf, false))
+	goodImageURL := imgUpload(t, srv)
+	get(srv, goodImageURL)
+	get(srv, goodImageURL)
+	assert.True(t, strings.Contains(logbuf.String(), "Uploaded:"))
+	for _, chatter := range []string{"Uploading:", "Upload headers:", "s0Get", "is cached."} {
+		assert.False(t, strings.Contains(logbuf.String(), chatter), chatter)
+	}
+}
+func TestResizeCached(t *testing.T) {
+	logbuf := new(logbuf)
+	srv := newTestServer(t, logTo(logbuf, true))
+	goodImageURL := imgUpload(t, srv)
+	id := strings.TrimSuffix(strings.TrimPrefix(goodImageURL, "/"), ".jpg")
+
+	first := get(srv, "/32/0/"+id+".jpg").Body.Bytes()
+	assert.NotEmpty(t, first)
+	assert.False(t, strings.Contains(logbuf.String(), "is cached."))
+
+	// Same transform, other route
+	second := get(srv, "/"+id+".JPEG/32/0").Body.Bytes()
+	assert.True(t, strings.Contains(logbuf.String(), "is cached."))
+	assert.Equal(t, first, second)
+}
+
+func TestConditional(t *testing.T) {
+	srv := newTestServer(t, nil)
+	up := uploadJSON(t, srv, nil)
+
+	w := get(srv, up["url"])
+	assert.Equal(t, 200, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, srv.opts.CacheOriginals, w.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, w.Header().Get("Expires"))
+	modified := w.Header().Get("Last-Modified")
+	assert.NotEmpty(t, modified)
+
+	w = get(srv, up["url"], "If-None-Match", etag)
+	assert.Equal(t, 304, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+	w = get(srv, up["url"], "If-Modified-Since", modified)
+	assert.Equal(t, 304, w.Code)
+	w = get(srv, up["url"], "If-None-Match", `"other"`, "If-Modified-Since", modified)
+	assert.Equal(t, 200, w.Code)
+
+	// Thumbnails have their own ETag
+	w = get(srv, up["thumb"])
+	assert.Equal(t, 200, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	w = get(srv, up["thumb"], "If-None-Match", w.Header().Get("ETag"))
+	assert.Equal(t, 304, w.Code)
+
+	w = get(srv, "/")
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+}
+
+func TestContentTypeRange(t *testing.T) {
+	srv := newTestServer(t, nil)
+	up := uploadJSON(t, srv, nil)
+	picbuf, _ := ioutil.ReadFile("testdata/one.jpeg")
+	for _, path := range []string{up["url"], up["thumb"]} {
+		req := httptest.NewRequest("HEAD", path, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, path)
+		assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"), path)
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"), path)
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"), path)
+		assert.NotEmpty(t, w.Header().Get("Content-Length"), path)
+		assert.Empty(t, w.Body.Bytes(), path)
+	}
+
+	w := get(srv, up["url"], "Range", "bytes=0-9")
+	assert.Equal(t, 206, w.Code)
+	assert.Equal(t, picbuf[:10], w.Body.Bytes())
+	assert.Equal(t, fmt.Sprintf("bytes 0-9/%d", len(picbuf)), w.Header().Get("Content-Range"))
+}
+
+func TestNoSniffHTML(t *testing.T) {
+	srv := newTestServer(t, nil)
+	body := new(bytes.Buffer)
+	ww := multipart.NewWriter(body)
+	formWriter, _ := ww.CreateFormFile("file", "evil.png")
+	formWriter.Write([]byte("<html><script>alert(1)</script></html>"))
+	ww.Close()
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Add("Content-Type", ww.FormDataContentType())
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	slash := strings.Split(w.Header().Get("Location"), "/")
+	w = get(srv, "/"+slash[len(slash)-1])
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+}
+
+func TestImgTrashed(t *testing.T) {
+	// We look for a line thats only available in -debug mode
+	logbuf := new(logbuf)
+	srv := newTestServer(t, logTo(logbuf, true))
+
+	w := get(srv, "/00XX00.jpg") // most likely not created yet
+	assert.True(t, strings.Contains(logbuf.String(), "Image not found"))
+	assert.Equal(t, 404, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "404 Not Found"))
+	if !t.Failed() {
+		fmt.Println("[TestImgTrashed] Successful 404")
+	}
+}
+
+func TestErrorBodies(t *testing.T) {
+	srv := newTestServer(t, func(o *Options) { o.Placeholder, _ = ioutil.ReadFile("testdata/one.jpeg") })
+	w := get(srv, "/320/0/00XX00.jpg", "Accept", "application/json")
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"error\":\"image not found\",\"status\":404}\n", w.Body.String())
+
+	w = get(srv, "/320/0/00XX00.bmp", "Accept", "text/html")
+	assert.Equal(t, 400, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "400 Bad Request"))
+	for _, path := range []string{"/0/0/00XX00.png", "/100000/100000/00XX00.jpg", "/4097/0/00XX00.jpg"} {
+		assert.Equal(t, 400, get(srv, path, "Accept", "text/html").Code, path)
+	}
+
+	// <img> gets the placeholder
+	w = get(srv, "/320/0/00XX00.jpg", "Accept", "image/webp,image/*,*/*;q=0.8")
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	assert.Equal(t, srv.opts.Placeholder, w.Body.Bytes())
+
+	// Not an image
+	srv.store.Put("00YY00", []byte("hello"))
+	w = get(srv, "/320/0/00YY00.jpg", "Accept", "application/json")
+	assert.Equal(t, 422, w.Code)
+}
+
+func TestLimitBorder(t *testing.T) {
+	logbuf := new(logbuf)
+	srv := newTestServer(t, logTo(logbuf, false))
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+
+		wg.Add(1)
+		time.Sleep(1000 * time.Millisecond)
+		go func() {
+			fmt.Println("Uploading and Viewing 1") // upload : 5 view : 1 = 6
+			imgUpload(t, srv)
+			wg.Done()
+		}()
+
+		fmt.Println("Waiting 10 seconds and uploading another")
+		time.Sleep(10 * time.Second)
+	}
+
+	wg.Wait()
+	// shouldn't take too long :D
+
+	assert.False(t, strings.Contains(logbuf.String(), "Not serving, rate limited"))
+
+}
+
+func TestLimit(t *testing.T) {
+	logbuf := new(logbuf)
+	srv := newTestServer(t, logTo(logbuf, false))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			imgUpload(t, srv)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	// shouldn't take too long :D
+
+	assert.True(t, strings.Contains(logbuf.String(), "Not serving, rate limited"))
+
+}
+
+func TestServerAPI(t *testing.T) {
+	srv := newTestServer(t, nil)
+	b, _ := ioutil.ReadFile("testdata/one.jpeg")
+	id, token, e := srv.Upload(b, 0)
+	assert.Nil(t, e)
+	assert.Len(t, id, srv.opts.IDLength)
+
+	// Same bytes as the URL would get
+	thumb, e := srv.Render(Transform{ID: id, Width: 16, Ext: "jpg"})
+	assert.Nil(t, e)
+	assert.Equal(t, thumb, get(srv, "/16/0/"+id+".jpg").Body.Bytes())
+	resized, e := Resize(b, 16, 0, "jpg")
+	assert.Nil(t, e)
+	assert.Equal(t, thumb, resized)
+	_, e = srv.Render(Transform{ID: id, Width: 16, Ext: "bmp"})
+	assert.NotNil(t, e)
+	_, e = Resize([]byte("hello"), 16, 0, "png")
+	assert.Equal(t, errDecode, e)
+
+	assert.NotNil(t, srv.Delete(id, "nope"))
+	assert.Nil(t, srv.Delete(id, token))
+	assert.Equal(t, errNotFound, srv.Delete(id, token))
+}
+
+func TestServerReload(t *testing.T) {
+	srv := newTestServer(t, nil)
+	keys := []*APIKey{{Name: "backend", Key: "s3cret"}}
+	srv.Reload(Options{Rate: 1, Burst: 40, Costs: map[string]float64{"upload": 7}, CacheSize: 1000, APIKeys: keys})
+	_, burst := srv.limiter.Limits()
+	assert.Equal(t, 40.0, burst)
+	assert.Equal(t, 7.0, srv.costs["upload"])
+	assert.Equal(t, int64(1000), srv.c1.Budget)
+	keys[0].admit(60)
+
+	// Usage carries over to the new keys
+	srv.Reload(Options{Rate: 1, Burst: 50, APIKeys: []*APIKey{{Name: "backend", Key: "s3cret"}}})
+	assert.Equal(t, int64(60), srv.apikeys[0].stored)
+	assert.Equal(t, 5.0, srv.costs["upload"])
+}
+
+var homegold = `<!DOCTYPE html>
+<html>
+<head>
+	<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+	<meta name="viewport" content="width=device-width">
+	<meta name="theme-color" content="#375EAB">
+	<title>Thumber v1</title>
+<style>
+body{
+  color: green;
+  background-color:   #E0EBF5;
+}
+.box {
+border: 1px solid black;
+min-width: 100px;
+margin: 10px;
+float: left;
+clear: none;
+padding: 10px;
+}
+</style>
+<body>
+
+
+<h1>Thumber</h1>
+<h2>Thumbnail Server</h2>
+<h3> Upload a file </h3>
+<form id="post" action="/upload" enctype="multipart/form-data" method="POST">
+		<input name="file" type="file" required/></input>
+    <br><input id="upload-submit" type="submit" value="upload" />
+</form>
+<pre style="background-color: lightgrey; width: 300px;">
+Public API:
+
+Original Size: /fileID
+Resize: /width/height/fileID
+Resize: /fileID/width/height (alt)
+Upload: POST /upload
+Expire: POST /upload expires=24h
+Delete: DELETE /fileID (X-Delete-Token)
+
+Example: /640/480/cat.jpeg
+
+</pre>
+</body></html>`
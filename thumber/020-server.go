@@ -0,0 +1,376 @@
+package thumber
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) s0ResizeExt(w http.ResponseWriter, r *http.Request) {
+
+	s.debug("New resizor")
+	t, e := s.parseTransform(r)
+	if e == errNotFound {
+		s.httpError(w, r, http.StatusNotFound, e.Error())
+		return
+	}
+	if e != nil {
+		s.logger.Println(e)
+		s.httpError(w, r, http.StatusBadRequest, e.Error())
+		return
+	}
+	if !s.ifCachedDo(w, r) { // serves c1 hits
+		return
+	}
+	// Renders are limited by the pool, not Options.MaxUsers: waiting for
+	// one mustn't hold up cache hits and originals
+	s.unlimit()
+
+	// One render per transform, however many ask at once, on the worker pool
+	note := noted(r)
+	note.Transform = fmt.Sprintf("%dx%d.%s", t.Width, t.Height, t.Ext)
+	note.Cache = "miss"
+	var b []byte
+	var shared bool
+	for {
+		b, e, shared = s.renders.Do(t.Key(), func() ([]byte, error) {
+			if b, ok := s.diskcached(t); ok {
+				note.Cache = "disk"
+				return b, nil
+			}
+			return s.pool.Do(r.Context(), func() ([]byte, error) { return s.render(t) })
+		})
+		// Whoever we waited on went away before a worker was free, not us
+		if !shared || !canceled(e) || r.Context().Err() != nil {
+			break
+		}
+	}
+	if shared {
+		s.debug("Coalesced render:", t.Key())
+		note.Cache = "coalesced"
+	}
+	if e != nil {
+		s.imageError(w, r, e)
+		return
+	}
+	serveImage(w, r, b)
+}
+
+// Render returns the image t asks for, from the caches if it can, or
+// rendered on the worker pool like a request for it would be.
+func (s *Server) Render(t Transform) ([]byte, error) {
+	t.Ext = normext(t.Ext)
+	if e := s.check(t); e != nil {
+		return nil, e
+	}
+	if m, _ := s.getmeta(t.ID); s.buried(t.ID) || s.gone(t.ID, m) {
+		return nil, errNotFound
+	}
+	if b, ok := s.c1.Get(t.Key()); ok {
+		return b, nil
+	}
+	b, e, _ := s.renders.Do(t.Key(), func() ([]byte, error) {
+		if b, ok := s.diskcached(t); ok {
+			return b, nil
+		}
+		return s.pool.Do(context.Background(), func() ([]byte, error) { return s.render(t) })
+	})
+	return b, e
+}
+
+// A render from the disk cache, moved up to c1
+func (s *Server) diskcached(t Transform) ([]byte, bool) {
+	if s.c2 == nil {
+		return nil, false
+	}
+	b, ok := s.c2.Get(t.Key())
+	if ok {
+		s.debug("Requested thumbnail is on disk. Not resizing.")
+		s.cache(t.ID, t.Key(), b, false)
+	}
+	return b, ok
+}
+
+// Render a transform from the original. Renders are cached.
+func (s *Server) render(t Transform) ([]byte, error) {
+	key := t.Key()
+	s.debug("Getting image:", t.ID)
+	t1 := time.Now()
+	im, e := s.getimage(t.ID)
+	if e != nil {
+		return nil, e
+	}
+	t2 := time.Now()
+	s.debug("Image read took:", t2.Sub(t1))
+	b, e := encode(im, t.Width, t.Height, t.Ext, s.metrics.renderStages)
+	if e != nil {
+		return nil, e
+	}
+
+	// Cache the render
+	s.cache(t.ID, key, b, true)
+	return b, nil
+}
+
+// Home page HTML form, caching disabled so we can redirect limited to home
+func (s *Server) s0Home(w http.ResponseWriter, r *http.Request) {
+
+	s.logreq(r)
+	if r.URL.Path != "/" || r.Method != "GET" {
+		s.debug("Home Redirecting:", r.URL.Path)
+		http.Redirect(w, r, s.url("/"), http.StatusFound)
+		return
+	}
+	w.Header().Set("Cache-Control", s.opts.CacheHome)
+	w.Write([]byte(s.header() + s.form() + footer))
+}
+
+// Anything else is 404, with the placeholder for <img> tags
+func (s *Server) s0NotFound(w http.ResponseWriter, r *http.Request) {
+	s.logreq(r)
+	s.httpError(w, r, http.StatusNotFound, "not found")
+}
+
+// Return an original size image (no encoding, cached and ratelimited)
+func (s *Server) s0Get(w http.ResponseWriter, r *http.Request) {
+	s.debug("s0Get")
+
+	if !s.ifCachedDo(w, r) {
+		return
+	}
+	defer s.unlimit()
+
+	// id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		s.debug("no id")
+		s.httpError(w, r, http.StatusNotFound, errNotFound.Error())
+		return
+	}
+
+	// Don't use extension, but test it.
+	ext := vars["ext"]
+	switch strings.ToLower(ext) {
+	case "png", "jpg", "jpeg", "gif":
+	default:
+		s.httpError(w, r, http.StatusBadRequest, "bad extension")
+		return
+	}
+	_ = ext
+
+	// Get image bytes directly from file.
+	noted(r).Cache = "miss"
+	b, e := s.getbytes(id)
+	if e != nil {
+		s.debug("Image not found,", e)
+		s.imageError(w, r, e)
+		return
+	}
+	if len(b) == 0 {
+		s.logger.Println("Image is 0 bytes")
+		s.httpError(w, r, http.StatusNotFound, errNotFound.Error())
+		return
+	}
+	// Set cache for ID
+	s.cache(id, origKey(id), b, false)
+
+	// Write to http response
+	serveImage(w, r, b)
+}
+
+// Upload an image (POST) and forward to a resized version.
+// The "uploaded" image is written exactly as the server receives it.
+// We don't know whether its a PNG, JPEG, or EXE at this point.
+func (s *Server) s0Upload(w http.ResponseWriter, r *http.Request) {
+	if !s.ifCachedDo(w, r) { // we dont cache here but we rate limit and log
+		return
+	}
+	defer s.unlimit()
+	ip := s.clientip(r)
+
+	if e := r.ParseMultipartForm(10000); e != nil {
+		s.logger.Println("Bad multipart form.", ip, r.Header.Get("Content-Type"))
+		s.httpError(w, r, http.StatusBadRequest, "bad multipart form")
+		return
+	}
+	// if strings.Split(r.Header.Get("Content-Type"), ";")[0] != "multipart/form-data" {
+	// 	log.Println("Not a multipart form.", ip, r.Header.Get("Content-Type"))
+	// 	http.Redirect(w, r, "/?bad", http.StatusForbidden)
+	// 	return
+	// }
+
+	_, fileheader, err := r.FormFile("file")
+	if err != nil {
+		s.logger.Println("File read error", err)
+		//	bod, _ := ioutil.ReadAll(r.Body)
+		//fmt.Println(bod)
+
+		//fmt.Println("Lnegth of req body", len(bod))
+		s.httpError(w, r, http.StatusBadRequest, "no file")
+		return
+	}
+
+	// Per-upload TTL, or the Options.Expire default
+	ttl := s.opts.Expire
+	if v := r.FormValue("expires"); v != "" {
+		d, e := time.ParseDuration(v)
+		if e != nil || d < 0 {
+			s.logger.Println("Bad expires:", ip, v)
+			s.httpError(w, r, http.StatusBadRequest, "bad expires")
+			return
+		}
+		ttl = d
+	}
+
+	s.debug("Upload headers:", fileheader.Header)
+	nameparts := strings.Split(fileheader.Filename, ".")
+	extension := nameparts[len(nameparts)-1]
+	s.debug("Uploading:", fileheader.Filename, extension)
+
+	// Read file into memory
+	openfile, err := fileheader.Open()
+	if err != nil {
+		s.logger.Println(r, err)
+		s.httpError(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	// Read the file into buffer (only to cache it)
+	var buf bytes.Buffer
+	i, e := buf.ReadFrom(openfile)
+	if e != nil {
+		s.logger.Println(r, i, e)
+		s.httpError(w, r, http.StatusBadRequest, "bad file")
+		return
+	}
+
+	// API keys have upload quotas
+	key, _ := s.apikey(r)
+	if key != nil {
+		if code, retry := key.admit(int64(buf.Len())); code != 0 {
+			s.logger.Println("Upload over quota:", key.Name, code)
+			if retry > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds(retry)))
+			}
+			s.httpError(w, r, code, "over quota")
+			return
+		}
+	}
+
+	name := ""
+	if key != nil {
+		name = key.Name
+	}
+	meta, token, e := s.upload(buf.Bytes(), ttl, name)
+	if e != nil {
+		if key != nil {
+			key.refund(int64(buf.Len()))
+		}
+		s.logger.Println("Upload failed:", e)
+		s.httpError(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+	id := meta.ID
+	noted(r).Image = id
+	w.Header().Set("X-Delete-Token", token)
+
+	// API clients get the token in the body too
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		resp := map[string]string{
+			"id":     id,
+			"url":    s.url("/" + id + "." + extension),
+			"thumb":  s.url("/320/0/" + id + "." + extension),
+			"delete": token,
+		}
+		if !meta.Expires.IsZero() {
+			resp["expires"] = meta.Expires.Format(time.RFC3339)
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// Redirect to a 320xAutoHeight thumbnail
+	thumb := s.url("/320/0/" + id + "." + extension)
+	s.debug("Redirecting to:", thumb)
+	http.Redirect(w, r, thumb, http.StatusFound)
+}
+
+// Upload stores b as a new upload, deleted after ttl (0 keeps it), and
+// returns its ID and deletion token. Nothing checks that b is an image.
+func (s *Server) Upload(b []byte, ttl time.Duration) (id, token string, err error) {
+	m, token, e := s.upload(b, ttl, "")
+	if e != nil {
+		return "", "", e
+	}
+	return m.ID, token, nil
+}
+
+// Store b and its metadata, for API key name if there is one
+func (s *Server) upload(b []byte, ttl time.Duration, keyname string) (*Meta, string, error) {
+	// Generate new ID
+	id := s.unique()
+
+	// Write the file.
+	if e := s.store.Put(id, b); e != nil {
+		return nil, "", fmt.Errorf("%s: %v", id, e)
+	}
+
+	// Issue a deletion token. Only its hash is kept.
+	token := tokengen()
+	meta := &Meta{ID: id, TokenHash: tokenhash(token), Created: time.Now(), Hash: sha256hex(b), Size: int64(len(b)), Key: keyname}
+	if ttl > 0 {
+		meta.Expires = meta.Created.Add(ttl)
+	}
+
+	// Without metadata the token can't work and the TTL is lost
+	if e := s.putmeta(meta); e != nil {
+		if er := s.remove(id); er != nil {
+			s.logger.Println("Upload:", id, er)
+		}
+		return nil, "", fmt.Errorf("%s: %v", id, e)
+	}
+	if ttl > 0 {
+		s.setexpiry(id, meta.Expires)
+	}
+	s.logger.Println("Uploaded:", id)
+	s.metrics.uploadCount.Add(1)
+	s.metrics.uploadBytes.Add(float64(len(b)))
+	return meta, token, nil
+}
+
+// Generate random string
+func keygen(n int) string {
+	runes := []rune("abcdefg1234567890123456789012345678901234567890")
+	b := make([]rune, n)
+	for i := range b {
+		b[i] = runes[rand.Intn(len(runes))]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Make sure keygen is unique file
+func (s *Server) unique() string {
+	id := keygen(s.opts.IDLength)
+	if s.buried(id) || s.gone(id, nil) {
+		return s.unique() // still answering 404 or 410
+	}
+	_, er := s.store.Stat(id)
+	if er != nil {
+		if os.IsNotExist(er) {
+			return id
+		}
+		s.logger.Println(er)
+	}
+	return s.unique()
+}
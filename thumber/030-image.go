@@ -0,0 +1,86 @@
+package thumber
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// If a file is an image, this returns the image.Image of the file.
+// Files that aren't images are errDecode.
+func (s *Server) getimage(id string) (image.Image, error) {
+	b, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	im, format, e := decode(b, s.metrics.renderStages)
+	if e != nil {
+		s.debug("Not an image:", id, e)
+		return nil, errDecode
+	}
+	s.debug("Read Image:", format, id)
+	return im, nil
+}
+
+// Just read a file
+func (s *Server) getbytes(id string) ([]byte, error) {
+	b, err := s.store.Get(id)
+	if err != nil {
+
+		return nil, err
+	}
+	return b, nil
+}
+
+// Resize an image to width x height, 0 for either keeps the aspect ratio,
+// and encode it as ext: png, jpeg or gif.
+func Resize(src []byte, width, height int, ext string) ([]byte, error) {
+	im, _, e := decode(src, nil)
+	if e != nil {
+		return nil, errDecode
+	}
+	return encode(im, width, height, normext(ext), nil)
+}
+
+// Decode b, timed in stages if it isn't nil
+func decode(b []byte, stages *Metric) (image.Image, string, error) {
+	t0 := time.Now()
+	m, format, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+	stages.Since(t0, "decode", format)
+	return m, format, nil
+}
+
+// Resize and encode im, timed in stages if it isn't nil
+func encode(im image.Image, width, height int, ext string, stages *Metric) ([]byte, error) {
+	t0 := time.Now()
+	resized := imaging.Resize(im, width, height, imaging.Lanczos)
+	stages.Since(t0, "resize", ext)
+	var b bytes.Buffer
+	var er error
+
+	t0 = time.Now()
+	switch ext {
+	case "png":
+		er = png.Encode(&b, resized)
+	case "jpeg":
+		er = jpeg.Encode(&b, resized, nil)
+	case "gif":
+		er = gif.Encode(&b, resized, nil)
+	default:
+		er = fmt.Errorf("bad extension %q", ext)
+	}
+	if er != nil {
+		return nil, er
+	}
+	stages.Since(t0, "encode", ext)
+	return b.Bytes(), nil
+}
@@ -0,0 +1,64 @@
+package thumber
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// LogLiner listens for requests to come in and formats them into a log line.
+// Rate limiting is done by limiter, in ifCachedDo.
+func (s *Server) logs() {
+	var totalhits int
+	for {
+		t1 := time.Now()
+		// Receive request
+		var l *http.Request
+		select {
+		case l = <-s.logchan:
+		case <-s.done:
+			return
+		}
+
+		t2 := time.Now()
+
+		// t0 = request total time
+		t0 := t2
+		s.debug("Been waiting for new request for: ", t2.Sub(t1))
+		// Just want IP
+		ip := s.clientip(l)
+		// Increment total hit counter
+		totalhits++
+
+		// log the request
+		line := fmt.Sprintf("%v (#%d) %s %q %q > %q %q", ip, totalhits, l.Method, l.RequestURI, l.UserAgent(), l.RemoteAddr, l.Host)
+		if l.Referer() != "" {
+			line += "ref: " + l.Referer()
+		}
+		if s.opts.Debug {
+			s.logger.Println(line)
+			b, _ := ioutil.ReadAll(l.Body)
+			str := string(b)
+			s.logger.Println("request body:", len(str), l.URL.Path)
+
+		}
+		t2 = time.Now()
+		s.debug("Log function took:", t2.Sub(t0))
+	}
+}
+
+// Log v like Println, only with Options.Debug
+func (s *Server) debug(v ...interface{}) {
+	if s.opts.Debug {
+		s.logger.Output(2, fmt.Sprintln(v...))
+	}
+}
+
+// Send r to logs, unless s is closed and nothing is reading
+func (s *Server) logreq(r *http.Request) {
+	select {
+	case s.logchan <- r:
+	case <-s.done:
+	}
+}
@@ -0,0 +1,235 @@
+package thumber
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Default cost of each named route, in tokens. Override with Options.Costs.
+// Routes not named here, and 404s, cost 1. Free routes still get headers.
+var defaultCosts = map[string]float64{
+	"upload":   5,
+	"resize":   1,
+	"original": 1,
+	"delete":   1,
+	"home":     0,
+	"metrics":  0,
+}
+
+// Limiter is a token bucket rate limiter. Every client starts with Burst
+// tokens and gets Rate more per second, up to Burst. A request spends its
+// route's cost, and is limited if there isn't enough.
+type Limiter struct {
+	Rate  float64
+	Burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter with no clients
+func NewLimiter(rate, burst float64) *Limiter {
+	return &Limiter{Rate: rate, Burst: burst, buckets: map[string]*bucket{}}
+}
+
+// Allow spends cost tokens from key's bucket if it has them. It returns the
+// tokens left and, when not allowed, how long until cost tokens are there.
+func (l *Limiter) Allow(key string, cost float64) (ok bool, remaining float64, retry time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: l.Burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.Burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens >= cost {
+		b.tokens -= cost
+		return true, b.tokens, 0
+	}
+	if l.Rate <= 0 {
+		return false, b.tokens, time.Duration(math.MaxInt64)
+	}
+	wait := time.Duration((cost - b.tokens) / l.Rate * float64(time.Second))
+	return false, b.tokens, wait
+}
+
+// SetRate changes the limits. Clients keep the tokens they have, up to burst.
+func (l *Limiter) SetRate(rate, burst float64) {
+	l.mu.Lock()
+	l.Rate, l.Burst = rate, burst
+	l.mu.Unlock()
+}
+
+// Limits returns Rate and Burst
+func (l *Limiter) Limits() (rate, burst float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Rate, l.Burst
+}
+
+// Evict forgets clients whose buckets have refilled. A full bucket is
+// the same as a new one, so nobody gets a free burst out of this.
+func (l *Limiter) Evict() int {
+	var n int
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Rate <= 0 {
+		return 0
+	}
+	full := time.Now().Add(-time.Duration(l.Burst / l.Rate * float64(time.Second)))
+	for key, b := range l.buckets {
+		if b.last.Before(full) {
+			delete(l.buckets, key)
+			n++
+		}
+	}
+	return n
+}
+
+// Reset is how long until a bucket with remaining tokens is full again
+func (l *Limiter) Reset(remaining float64) time.Duration {
+	rate, burst := l.Limits()
+	if rate <= 0 || remaining >= burst {
+		return 0
+	}
+	return time.Duration((burst - remaining) / rate * float64(time.Second))
+}
+
+// Len is how many clients are being tracked
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// Tell the client where it stands, with RateLimit-* headers, and when to
+// come back with Retry-After if it's limited. Times are whole seconds, rounded up.
+func rateHeaders(w http.ResponseWriter, l *Limiter, ok bool, remaining float64, retry time.Duration) {
+	h := w.Header()
+	_, burst := l.Limits()
+	h.Set("RateLimit-Limit", strconv.Itoa(int(burst)))
+	h.Set("RateLimit-Remaining", strconv.Itoa(int(remaining)))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(l.Reset(remaining))))
+	if !ok {
+		h.Set("Retry-After", strconv.Itoa(seconds(retry)))
+	}
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(math.Min(d.Seconds(), math.MaxInt32)))
+}
+
+// Rate limit every request but the quiet ones, so every response has
+// RateLimit-* headers, errors included. A key with a Rate of its own spends
+// only from its bucket. Other keyed requests spend per IP, but apart from
+// the client's anonymous requests: one busy key can't lock out a whole NAT.
+func (s *Server) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if quiet[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := s.clientip(r)
+		key, sent := s.apikey(r)
+		l, bucket := s.limiter, s.limitkey(ip)
+		if key != nil {
+			noted(r).User = key.Name
+			if key.requests != nil {
+				l, bucket = key.requests, ""
+			} else {
+				bucket = key.Name + "@" + bucket
+			}
+		}
+		if !s.opts.NoRateLimit {
+			ok, remaining, retry := l.Allow(bucket, s.cost(r))
+			rateHeaders(w, l, ok, remaining, retry)
+			if !ok {
+				s.logger.Println("Not serving, rate limited:", ip)
+				s.metrics.rateLimited.Add(1)
+				s.httpError(w, r, http.StatusTooManyRequests, "rate limited")
+				return
+			}
+		}
+		// Charged like an anonymous request first, so guessing keys is limited too
+		if sent && key == nil {
+			s.logger.Println("Bad API key:", ip)
+			s.httpError(w, r, http.StatusUnauthorized, "bad api key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Cost of a request, by route name
+func (s *Server) cost(r *http.Request) float64 {
+	if route := mux.CurrentRoute(r); route != nil {
+		s.confmu.RLock()
+		c, ok := s.costs[route.GetName()]
+		s.confmu.RUnlock()
+		if ok {
+			return c
+		}
+	}
+	return 1
+}
+
+// ParseCosts reads route costs like "upload=5,resize=1", as for Options.Costs.
+func ParseCosts(s string) (map[string]float64, error) {
+	return costsFrom(nil, s)
+}
+
+// The default costs with c over them
+func withCosts(c map[string]float64) map[string]float64 {
+	m, _ := costsFrom(defaultCosts, "")
+	for route, n := range c {
+		m[route] = n
+	}
+	return m
+}
+
+// base with the costs in s
+func costsFrom(base map[string]float64, s string) (map[string]float64, error) {
+	m := map[string]float64{}
+	for k, v := range base {
+		m[k] = v
+	}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad cost %q, want route=tokens", pair)
+		}
+		n, e := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if e != nil || n < 0 {
+			return nil, fmt.Errorf("bad cost %q", pair)
+		}
+		m[strings.TrimSpace(kv[0])] = n
+	}
+	return m, nil
+}
+
+// Forget idle clients now and then, so the map doesn't grow forever.
+func (s *Server) ratelimiter() {
+	for s.sleep(time.Minute) {
+		if n := s.limiter.Evict(); n > 0 && s.opts.Debug {
+			s.logger.Println("Rate limiter: forgot", n, "clients")
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package thumber
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(10, 5) // 10 tokens a second, 5 at once
+	ok, left, _ := l.Allow("1.2.3.4", 5)
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, left)
+	ok, _, retry := l.Allow("1.2.3.4", 1)
+	assert.False(t, ok)
+	assert.True(t, retry > 0 && retry <= 100*time.Millisecond, retry)
+
+	// Other clients have their own bucket
+	ok, _, _ = l.Allow("5.6.7.8", 1)
+	assert.True(t, ok)
+
+	time.Sleep(150 * time.Millisecond)
+	ok, _, _ = l.Allow("1.2.3.4", 1)
+	assert.True(t, ok)
+
+	// Refilled buckets are forgotten
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, 2, l.Evict())
+	assert.Equal(t, 0, l.Len())
+}
+
+func TestParseCosts(t *testing.T) {
+	c, e := ParseCosts("upload=2, resize=0.5")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]float64{"upload": 2, "resize": 0.5}, c)
+	assert.Equal(t, map[string]float64{"upload": 2, "resize": 0.5, "original": 1, "delete": 1, "home": 0, "metrics": 0}, withCosts(c))
+	_, e = ParseCosts("upload")
+	assert.NotNil(t, e)
+	_, e = ParseCosts("upload=-1")
+	assert.NotNil(t, e)
+}
+
+func TestRateHeaders(t *testing.T) {
+	srv := newTestServer(t, func(o *Options) { o.Rate, o.Burst = 0.5, 2 })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/00ZZ00.jpg", nil)
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "", w.Header().Get("Retry-After"))
+
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, "4", w.Header().Get("RateLimit-Reset"))
+
+	// Every response has them, whatever the route or status, and free
+	// routes are never limited
+	for _, path := range []string{"/", "/0/0/00ZZ00.png", "/99999/1/00ZZ00.png", "/short.png"} {
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"), path)
+	}
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, 200, w.Code)
+}
@@ -0,0 +1,126 @@
+package thumber
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrusted reads proxies like "127.0.0.1/32,10.0.0.0/8,::1", for
+// Options.TrustedProxies. Bare IPs are ok.
+func ParseTrusted(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("bad trusted proxy %q", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			c = fmt.Sprintf("%s/%d", c, bits)
+		}
+		_, n, e := net.ParseCIDR(c)
+		if e != nil {
+			return nil, e
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (s *Server) istrusted(ip net.IP) bool {
+	s.confmu.RLock()
+	defer s.confmu.RUnlock()
+	for _, n := range s.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// The headers a trusted proxy can pass the client's IP in, for
+// Options.ProxyHeader
+var proxyHeaders = map[string]bool{"": true, "X-Forwarded-For": true, "Forwarded": true, "X-Real-Ip": true}
+
+// The client's IP. Options.ProxyHeader, and only that header, is believed
+// when the peer is a trusted proxy, and then the client is the last address
+// in the chain that isn't one of our proxies. The others may be the client's
+// own, passed through. Peers on a unix socket ("@") are local, so always
+// trusted.
+func (s *Server) clientip(r *http.Request) string {
+	peer := getip(r.RemoteAddr)
+	ip := net.ParseIP(peer)
+	if peer != "@" && (ip == nil || !s.istrusted(ip)) {
+		return peer
+	}
+	// Proxies may append their own header line rather than extend the
+	// client's, so all the lines make the chain
+	var chain []string
+	switch http.CanonicalHeaderKey(s.opts.ProxyHeader) {
+	case "Forwarded":
+		chain = forwardedFor(strings.Join(r.Header.Values("Forwarded"), ","))
+	case "X-Real-Ip":
+		if real := r.Header.Get("X-Real-IP"); real != "" {
+			chain = []string{real}
+		}
+	default:
+		if xff := strings.Join(r.Header.Values("X-Forwarded-For"), ","); xff != "" {
+			chain = strings.Split(xff, ",")
+		}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := net.ParseIP(stripport(strings.TrimSpace(chain[i])))
+		if hop == nil {
+			break // garbage, stop believing
+		}
+		ip = hop
+		if !s.istrusted(hop) {
+			break
+		}
+	}
+	if ip == nil {
+		return peer
+	}
+	return ip.String()
+}
+
+// The for= addresses of an RFC 7239 Forwarded header, in order.
+func forwardedFor(header string) []string {
+	var addrs []string
+	for _, elem := range strings.Split(header, ",") {
+		for _, pair := range strings.Split(elem, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+				addrs = append(addrs, strings.Trim(kv[1], `"`))
+			}
+		}
+	}
+	return addrs
+}
+
+// "1.2.3.4:80" to "1.2.3.4", "[::1]:80" and "[::1]" to "::1"
+func stripport(addr string) string {
+	if host, _, e := net.SplitHostPort(addr); e == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// Rate limiting key for an IP. IPv6 clients usually have a whole /64,
+// so with -ipv6-prefix they share one bucket.
+func (s *Server) limitkey(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil || s.opts.IPv6Prefix <= 0 || s.opts.IPv6Prefix >= 128 {
+		return ip
+	}
+	return parsed.Mask(net.CIDRMask(s.opts.IPv6Prefix, 128)).String() + fmt.Sprintf("/%d", s.opts.IPv6Prefix)
+}
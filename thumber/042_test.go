@@ -0,0 +1,77 @@
+package thumber
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, e := ParseTrusted("127.0.0.1, 10.0.0.0/8, ::1")
+	assert.Nil(t, e)
+	srv := &Server{trusted: trusted}
+	forwarded := &Server{trusted: trusted, opts: Options{ProxyHeader: "Forwarded"}}
+	realip := &Server{trusted: trusted, opts: Options{ProxyHeader: "X-Real-IP"}}
+	_, e = ParseTrusted("10.0.0.0/99")
+	assert.NotNil(t, e)
+
+	for _, c := range []struct {
+		srv                       *Server
+		peer, header, value, want string
+	}{
+		{srv, "203.0.113.9:1234", "", "", "203.0.113.9"},
+		{srv, "[2001:db8::1]:1234", "", "", "2001:db8::1"},
+		// Untrusted peers can't pick their IP
+		{srv, "203.0.113.9:1234", "X-Forwarded-For", "1.1.1.1", "203.0.113.9"},
+		{srv, "127.0.0.1:1234", "X-Forwarded-For", "1.1.1.1, 198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{srv, "127.0.0.1:1234", "X-Forwarded-For", "10.0.0.3", "10.0.0.3"},
+		{realip, "127.0.0.1:1234", "X-Real-IP", "198.51.100.7", "198.51.100.7"},
+		{forwarded, "[::1]:1234", "Forwarded", `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`, "2001:db8:cafe::17"},
+		{srv, "127.0.0.1:1234", "X-Forwarded-For", "garbage", "127.0.0.1"},
+		// Only the header the proxy sets; the client can send the others
+		{srv, "127.0.0.1:1234", "Forwarded", "for=6.6.6.6", "127.0.0.1"},
+		{srv, "127.0.0.1:1234", "X-Real-IP", "6.6.6.6", "127.0.0.1"},
+		{forwarded, "127.0.0.1:1234", "X-Forwarded-For", "6.6.6.6", "127.0.0.1"},
+		// Behind nginx on a unix socket
+		{srv, "@", "X-Forwarded-For", "198.51.100.7", "198.51.100.7"},
+		{srv, "@", "X-Forwarded-For", "garbage", "@"},
+		{srv, "@", "", "", "@"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.peer
+		if c.header != "" {
+			r.Header.Set(c.header, c.value)
+		}
+		assert.Equal(t, c.want, c.srv.clientip(r), c.peer, c.header, c.value)
+	}
+
+	// nginx appends to X-Forwarded-For and passes the client's Forwarded on
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("Forwarded", "for=6.6.6.6")
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, 203.0.113.9")
+	assert.Equal(t, "203.0.113.9", srv.clientip(r))
+	_, e = New(Options{ProxyHeader: "X-Client-IP"})
+	assert.NotNil(t, e)
+
+	// HAProxy's option forwardfor adds a line after the client's own
+	r = httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Add("X-Forwarded-For", "6.6.6.6")
+	r.Header.Add("X-Forwarded-For", "203.0.113.5")
+	assert.Equal(t, "203.0.113.5", srv.clientip(r))
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Add("Forwarded", "for=6.6.6.6")
+	r.Header.Add("Forwarded", "for=203.0.113.5")
+	assert.Equal(t, "203.0.113.5", forwarded.clientip(r))
+}
+
+func TestLimitKey(t *testing.T) {
+	srv := &Server{opts: Options{IPv6Prefix: 64}}
+	assert.Equal(t, "2001:db8:1:2::/64", srv.limitkey("2001:db8:1:2:aaaa::1"))
+	assert.Equal(t, srv.limitkey("2001:db8:1:2::5"), srv.limitkey("2001:db8:1:2:ffff::9"))
+	assert.Equal(t, "192.0.2.1", srv.limitkey("192.0.2.1"))
+	srv.opts.IPv6Prefix = 0
+	assert.Equal(t, "2001:db8::1", srv.limitkey("2001:db8::1"))
+}
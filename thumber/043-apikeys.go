@@ -0,0 +1,212 @@
+package thumber
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey is a client with its own limits. Zero means no limit of its own.
+// A key with a Rate is limited only by it, across all IPs. Without one it is
+// limited per IP like anonymous clients, in buckets of its own.
+type APIKey struct {
+	Name         string  `json:"name"`
+	Key          string  `json:"key"`
+	Rate         float64 `json:"rate"`          // requests: tokens per second, across all IPs
+	Burst        float64 `json:"burst"`         // requests: most tokens saved up
+	UploadRate   float64 `json:"upload_rate"`   // uploads per second
+	UploadBurst  float64 `json:"upload_burst"`  // most uploads at once
+	DailyUploads int     `json:"daily_uploads"` // uploads per UTC day
+	MaxBytes     int64   `json:"max_bytes"`     // total size of stored uploads
+
+	requests *Limiter
+	uploads  *Limiter
+
+	mu     sync.Mutex
+	day    string
+	today  int
+	stored int64
+}
+
+// LoadKeys reads API keys from a JSON file, a list of APIKey.
+func LoadKeys(path string) ([]*APIKey, error) {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	var keys []*APIKey
+	if e = json.Unmarshal(b, &keys); e != nil {
+		return nil, fmt.Errorf("%s: %v", path, e)
+	}
+	names := map[string]bool{}
+	for _, k := range keys {
+		if k.Name == "" || k.Key == "" {
+			return nil, fmt.Errorf("%s: every key needs a name and a key", path)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("%s: duplicate key name %q", path, k.Name)
+		}
+		names[k.Name] = true
+		k.init()
+	}
+	return keys, nil
+}
+
+func (k *APIKey) init() {
+	if k.Rate > 0 {
+		if k.Burst < 1 {
+			k.Burst = k.Rate
+		}
+		k.requests = NewLimiter(k.Rate, k.Burst)
+	}
+	if k.UploadRate > 0 {
+		if k.UploadBurst < 1 {
+			k.UploadBurst = 1
+		}
+		k.uploads = NewLimiter(k.UploadRate, k.UploadBurst)
+	}
+}
+
+// The API key a request was sent with, from "Authorization: Bearer" or
+// X-API-Key. sent is true if there was one, even if it's wrong.
+func (s *Server) apikey(r *http.Request) (k *APIKey, sent bool) {
+	token := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); token == "" && len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		token = strings.TrimSpace(auth[7:])
+	}
+	if token == "" {
+		return nil, false
+	}
+	s.confmu.RLock()
+	defer s.confmu.RUnlock()
+	for _, k := range s.apikeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(k.Key)) == 1 {
+			return k, true
+		}
+	}
+	return nil, true
+}
+
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// Admit an upload of size bytes. Returns 0 if ok, or the HTTP status and how
+// long until it would be ok (0 if waiting won't help).
+func (k *APIKey) admit(size int64) (code int, retry time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.day != today() {
+		k.day, k.today = today(), 0
+	}
+	if k.MaxBytes > 0 && k.stored+size > k.MaxBytes {
+		return http.StatusRequestEntityTooLarge, 0
+	}
+	if k.DailyUploads > 0 && k.today >= k.DailyUploads {
+		now := time.Now().UTC()
+		return http.StatusTooManyRequests, now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+	}
+	if k.uploads != nil {
+		if ok, _, retry := k.uploads.Allow("", 1); !ok {
+			return http.StatusTooManyRequests, retry
+		}
+	}
+	k.today++
+	k.stored += size
+	return 0, 0
+}
+
+// Give back stored bytes when an upload goes away
+func (k *APIKey) release(size int64) {
+	k.mu.Lock()
+	k.stored -= size
+	if k.stored < 0 {
+		k.stored = 0
+	}
+	k.mu.Unlock()
+}
+
+// Undo admit, for an upload that wasn't stored
+func (k *APIKey) refund(size int64) {
+	k.mu.Lock()
+	if k.day == today() && k.today > 0 {
+		k.today--
+	}
+	k.mu.Unlock()
+	k.release(size)
+}
+
+// Find an API key by name
+func (s *Server) keynamed(name string) *APIKey {
+	s.confmu.RLock()
+	defer s.confmu.RUnlock()
+	for _, k := range s.apikeys {
+		if k.Name == name {
+			return k
+		}
+	}
+	return nil
+}
+
+// Reloaded keys keep their usage so far, by name. Rate limits start over.
+func carryUsage(old, keys []*APIKey) []*APIKey {
+	for _, k := range keys {
+		for _, o := range old {
+			if o.Name != k.Name {
+				continue
+			}
+			o.mu.Lock()
+			k.day, k.today, k.stored = o.day, o.today, o.stored
+			o.mu.Unlock()
+		}
+	}
+	return keys
+}
+
+// Count what each key has stored and uploaded today, from the metadata.
+func (s *Server) countUsage() {
+	if len(s.apikeys) == 0 {
+		return
+	}
+	keys, e := s.store.List("")
+	if e != nil {
+		s.logger.Println("Usage:", e)
+		return
+	}
+	day := today()
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".meta") {
+			continue
+		}
+		m, e := s.readmeta(strings.TrimSuffix(key, ".meta"))
+		if e != nil || m.Key == "" {
+			continue
+		}
+		k := s.keynamed(m.Key)
+		if k == nil {
+			continue
+		}
+		k.mu.Lock()
+		k.day = day
+		k.stored += m.Size
+		if m.Created.UTC().Format("2006-01-02") == day {
+			k.today++
+		}
+		k.mu.Unlock()
+	}
+}
+
+// An upload is gone, give its bytes back to its key
+func (s *Server) unaccount(m *Meta) {
+	if m.Key == "" {
+		return
+	}
+	if k := s.keynamed(m.Key); k != nil {
+		k.release(m.Size)
+	}
+}
@@ -0,0 +1,156 @@
+package thumber
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Upload testdata/one.jpeg to srv with an API key
+func uploadWithKey(t *testing.T, srv *Server, key string) *httptest.ResponseRecorder {
+	picbuf, err := ioutil.ReadFile("testdata/one.jpeg")
+	assert.Nil(t, err)
+	body := new(bytes.Buffer)
+	ww := multipart.NewWriter(body)
+	formWriter, err := ww.CreateFormFile("file", "null.jpg")
+	assert.Nil(t, err)
+	formWriter.Write(picbuf)
+	assert.Nil(t, ww.Close())
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", ww.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	return w
+}
+
+// Storage that can't write metadata
+type nometaStorage struct {
+	Storage
+}
+
+func (n nometaStorage) Put(key string, b []byte) error {
+	if strings.HasSuffix(key, ".meta") {
+		return errors.New("disk full")
+	}
+	return n.Storage.Put(key, b)
+}
+
+func TestUploadNoMeta(t *testing.T) {
+	srv := newTestServer(t, func(o *Options) {
+		o.Storage = nometaStorage{NewMemStorage()}
+		o.APIKeys = []*APIKey{{Name: "daily", Key: "daily-key", DailyUploads: 1}}
+	})
+	pic, _ := ioutil.ReadFile("testdata/one.jpeg")
+	_, _, e := srv.Upload(pic, 0)
+	assert.NotNil(t, e)
+	keys, _ := srv.store.List("")
+	assert.Empty(t, keys)
+
+	// Not counted against the key either
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, 500, uploadWithKey(t, srv, "daily-key").Code)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "keys")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	ioutil.WriteFile(path, []byte(`[{"name": "backend", "key": "s3cret", "rate": 100}]`), 0600)
+	keys, e := LoadKeys(path)
+	assert.Nil(t, e)
+	assert.Equal(t, 1, len(keys))
+	assert.Equal(t, 100.0, keys[0].requests.Burst)
+	assert.Nil(t, keys[0].uploads)
+
+	ioutil.WriteFile(path, []byte(`[{"name": "a", "key": "1"}, {"name": "a", "key": "2"}]`), 0600)
+	_, e = LoadKeys(path)
+	assert.NotNil(t, e)
+	ioutil.WriteFile(path, []byte(`[{"name": "nokey"}]`), 0600)
+	_, e = LoadKeys(path)
+	assert.NotNil(t, e)
+}
+
+func TestAPIKeys(t *testing.T) {
+	pic, _ := ioutil.ReadFile("testdata/one.jpeg")
+	size := int64(len(pic))
+	srv := newTestServer(t, func(o *Options) {
+		o.APIKeys = []*APIKey{
+			{Name: "big", Key: "big-key"},
+			{Name: "daily", Key: "daily-key", DailyUploads: 1},
+			{Name: "small", Key: "small-key", MaxBytes: size + size/2},
+			{Name: "norate", Key: "norate-key"},
+			{Name: "fast", Key: "fast-key", Rate: 1000, Burst: 1000},
+		}
+	})
+
+	// Drain this IP's bucket; keyed requests have their own
+	srv.limiter.Allow(srv.limitkey("192.0.2.1"), srv.opts.Burst)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 200, uploadWithKey(t, srv, "big-key").Code)
+	}
+	// Wrong keys are charged to the IP
+	assert.Equal(t, 429, uploadWithKey(t, srv, "wrong").Code)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	req.Header.Set("X-API-Key", "wrong")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+	assert.NotEqual(t, "", w.Header().Get("RateLimit-Remaining"))
+
+	assert.Equal(t, 200, uploadWithKey(t, srv, "daily-key").Code)
+	w = uploadWithKey(t, srv, "daily-key")
+	assert.Equal(t, 429, w.Code)
+	assert.NotEqual(t, "", w.Header().Get("Retry-After"))
+
+	// Failed uploads don't count
+	daily := srv.apikeys[1]
+	daily.refund(size)
+	assert.Equal(t, 0, daily.today)
+	code, _ := daily.admit(size)
+	assert.Equal(t, 0, code)
+
+	// No rate of its own is still limited per IP
+	var codes []int
+	for i := 0; i <= int(srv.opts.Burst); i++ {
+		req := httptest.NewRequest("GET", "/00ZZ00.jpg", nil)
+		req.Header.Set("X-API-Key", "norate-key")
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, 404, codes[0])
+	assert.Equal(t, 429, codes[len(codes)-1])
+
+	// A rate of its own is the only limit
+	for i := 0; i < 100; i++ {
+		w = get(srv, "/00ZZ00.jpg", "X-API-Key", "fast-key")
+		if w.Code != 404 {
+			t.Fatalf("fast key request %d: %d", i, w.Code)
+		}
+	}
+
+	// Storage quota, given back when deleted
+	w = uploadWithKey(t, srv, "small-key")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 413, uploadWithKey(t, srv, "small-key").Code)
+	var up map[string]string
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &up))
+	assert.Equal(t, 204, srv.deleteUpload(up["id"], up["delete"]))
+	assert.Equal(t, int64(0), srv.apikeys[2].stored)
+	assert.Equal(t, 200, uploadWithKey(t, srv, "small-key").Code)
+}
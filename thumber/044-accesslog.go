@@ -0,0 +1,111 @@
+package thumber
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Access is what handlers tell the access log about a request.
+type Access struct {
+	Cache     string // hit, disk, coalesced or miss
+	Image     string // upload ID
+	Transform string // like 320x0.jpeg
+	User      string // API key name
+}
+
+type accessKey struct{}
+
+// The request's Access, to fill in. Requests outside accesslog get a throwaway.
+func noted(r *http.Request) *Access {
+	if a, ok := r.Context().Value(accessKey{}).(*Access); ok {
+		return a
+	}
+	return new(Access)
+}
+
+// A request ID from a proxy, if it looks sane, or a new one
+func requestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if id != "" && len(id) <= 64 && strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") == "" {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Log every request to accessLog, with what happened to it.
+func (s *Server) accesslog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.AccessLog == nil || quiet[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t0 := time.Now()
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		a := new(Access)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessKey{}, a)))
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		var line []byte
+		if s.opts.AccessFormat == "combined" {
+			line = s.combined(r, sw, a, t0)
+		} else {
+			line = s.jsonline(r, sw, a, t0, id)
+		}
+		s.accessMu.Lock()
+		s.opts.AccessLog.Write(line)
+		s.accessMu.Unlock()
+	})
+}
+
+func (s *Server) jsonline(r *http.Request, sw *statusWriter, a *Access, t0 time.Time, id string) []byte {
+	b, _ := json.Marshal(struct {
+		Time      string  `json:"time"`
+		RequestID string  `json:"request_id"`
+		IP        string  `json:"ip"`
+		Method    string  `json:"method"`
+		URI       string  `json:"uri"`
+		Proto     string  `json:"proto"`
+		Status    int     `json:"status"`
+		Bytes     int64   `json:"bytes"`
+		Duration  float64 `json:"duration_ms"`
+		Cache     string  `json:"cache,omitempty"`
+		Image     string  `json:"image,omitempty"`
+		Transform string  `json:"transform,omitempty"`
+		User      string  `json:"user,omitempty"`
+		Referer   string  `json:"referer,omitempty"`
+		UserAgent string  `json:"user_agent,omitempty"`
+	}{
+		t0.UTC().Format(time.RFC3339Nano), id, s.clientip(r), r.Method, r.RequestURI, r.Proto,
+		sw.code, sw.size, float64(time.Since(t0).Microseconds()) / 1000,
+		a.Cache, a.Image, a.Transform, a.User, r.Referer(), r.UserAgent(),
+	})
+	return append(b, '\n')
+}
+
+// Apache Combined Log Format
+func (s *Server) combined(r *http.Request, sw *statusWriter, a *Access, t0 time.Time) []byte {
+	user := a.User
+	if user == "" {
+		user = "-"
+	}
+	return []byte(fmt.Sprintf("%s - %s [%s] %q %d %d %q %q\n", s.clientip(r), user, t0.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.RequestURI+" "+r.Proto, sw.code, sw.size, dash(r.Referer()), dash(r.UserAgent())))
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,50 @@
+package thumber
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	srv := newTestServer(t, func(o *Options) { o.AccessLog = &buf })
+	up := uploadJSON(t, srv, nil)
+	buf.Reset()
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/32/0/"+up["id"]+".png", nil)
+		req.Header.Set("X-Request-ID", "abc-123")
+		req.Header.Set("User-Agent", "test")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		assert.Equal(t, "abc-123", w.Header().Get("X-Request-ID"))
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	var first, second map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Equal(t, "abc-123", first["request_id"])
+	assert.Equal(t, 200.0, first["status"])
+	assert.True(t, first["bytes"].(float64) > 0)
+	assert.Equal(t, "miss", first["cache"])
+	assert.Equal(t, "hit", second["cache"])
+	assert.Equal(t, up["id"], first["image"])
+	assert.Equal(t, "32x0.png", first["transform"])
+	assert.Equal(t, "test", first["user_agent"])
+
+	// Combined
+	srv = newTestServer(t, func(o *Options) { o.AccessLog, o.AccessFormat = &buf, "combined" })
+	buf.Reset()
+	get(srv, "/nope")
+	assert.Regexp(t, regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET /nope HTTP/1.1" 404 \d+ "-" "-"\n$`), buf.String())
+
+	// Made up request IDs get replaced
+	w := get(srv, "/nope", "X-Request-ID", "bad id\n")
+	assert.Regexp(t, "^[0-9a-f]{16}$", w.Header().Get("X-Request-ID"))
+}
@@ -0,0 +1,93 @@
+package thumber
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Hit is a request holding one of the Options.MaxUsers slots in ratelimit.
+type Hit struct {
+	IP   string
+	Time time.Time
+	Path string
+}
+
+// Quick! Log the request while limiting hit rate. Return false if cached.
+// If this returns true, the parent function should continue
+func (s *Server) ifCachedDo(w http.ResponseWriter, r *http.Request) bool {
+	s.logreq(r) // logchan limits global users
+
+	ip := s.clientip(r)
+
+	// every HandlerFunc must empty the ratelimiter when finished (defer unlimit())
+	// logchan and ratelimit together will limit the amount of traffic to the server.
+	// Rate limiting itself is done before the handler, see limit.
+	select {
+	case s.ratelimit <- Hit{Time: time.Now(), IP: ip}:
+	case <-r.Context().Done():
+		s.imageError(w, r, r.Context().Err())
+		return false
+	}
+
+	// only cache GETs
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return true
+	}
+	path := s.cacheKey(r)
+	if path == "" {
+		return true
+	}
+
+	// Deleted and expired uploads are gone, cached or not
+	id := mux.Vars(r)["id"]
+	if s.buried(id) {
+		s.httpError(w, r, http.StatusNotFound, errNotFound.Error())
+		s.unlimit()
+		return false
+	}
+	m, _ := s.getmeta(id)
+	if s.gone(id, m) {
+		s.httpError(w, r, http.StatusGone, "image expired")
+		s.unlimit()
+		return false
+	}
+	// Caching headers, and 304 if the client has it already
+	noted(r).Image = id
+	if s.cacheHeaders(w, r, m, id, path) {
+		noted(r).Cache = "hit"
+		s.unlimit()
+		return false
+	}
+	cached, ok := s.c1.Get(path)
+	if !ok {
+		s.debug("Not cached:", path)
+		return true
+	}
+
+	// Has a cache.
+	s.debug("Requested thumbnail is cached. Not resizing.")
+	noted(r).Cache = "hit"
+	serveImage(w, r, cached)
+	s.unlimit() // Empty ratelimiter 1
+	return false
+}
+
+// Purge every cached rendition of file ID
+func (s *Server) purge(id string) {
+	s.c1.Purge(id + "/")
+	if s.c2 != nil {
+		s.c2.Purge(id + "/")
+	}
+}
+
+// Empty the ratelimiter by one
+func (s *Server) unlimit() {
+	<-s.ratelimit
+}
+
+// Split something like 10.4.2.0:32040 into 10.4.2.0, or [::1]:32040 into ::1
+func getip(req string) string {
+	return stripport(req)
+}
@@ -0,0 +1,190 @@
+package thumber
+
+import (
+	"container/list"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskCache keeps rendered bytes in a directory, evicting the least recently
+// used files when the total size goes over Budget.
+type DiskCache struct {
+	Dir    string
+	Budget int64
+
+	mu    sync.Mutex
+	size  int64
+	ll    *list.List // front is most recently used
+	items map[string]*list.Element
+	stats CacheStats
+}
+
+type diskitem struct {
+	key  string
+	size int64
+}
+
+// NewDiskCache opens (or creates) a disk cache in dir. Files left by a
+// previous run are kept, oldest first in line for eviction.
+func NewDiskCache(dir string, budget int64) (*DiskCache, error) {
+	if e := os.MkdirAll(dir, 0700); e != nil {
+		return nil, e
+	}
+	d := &DiskCache{Dir: dir, Budget: budget, ll: list.New(), items: map[string]*list.Element{}}
+	files, e := ioutil.ReadDir(dir)
+	if e != nil {
+		return nil, e
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	for _, fi := range files {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		key, e := url.PathUnescape(fi.Name())
+		if e != nil {
+			continue
+		}
+		d.items[key] = d.ll.PushBack(&diskitem{key: key, size: fi.Size()})
+		d.size += fi.Size()
+	}
+	d.mu.Lock()
+	d.evict()
+	d.mu.Unlock()
+	return d, nil
+}
+
+func (d *DiskCache) path(key string) string {
+	return filepath.Join(d.Dir, url.PathEscape(key))
+}
+
+// Get cached bytes for key
+func (d *DiskCache) Get(key string) ([]byte, bool) {
+	d.mu.Lock()
+	el, ok := d.items[key]
+	if ok {
+		d.ll.MoveToFront(el)
+		d.stats.Hits++
+	} else {
+		d.stats.Misses++
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	b, e := ioutil.ReadFile(d.path(key))
+	if e != nil {
+		d.Delete(key)
+		return nil, false
+	}
+	// remember recency across restarts
+	now := time.Now()
+	os.Chtimes(d.path(key), now, now)
+	return b, true
+}
+
+// Set writes b for key, then evicts down to the budget.
+func (d *DiskCache) Set(key string, b []byte) error {
+	d.mu.Lock()
+	budget := d.Budget
+	d.mu.Unlock()
+	if int64(len(b)) > budget {
+		return nil
+	}
+	tmp, e := ioutil.TempFile(d.Dir, ".tmp-")
+	if e != nil {
+		return e
+	}
+	_, e = tmp.Write(b)
+	if e1 := tmp.Close(); e == nil {
+		e = e1
+	}
+	if e == nil {
+		e = os.Rename(tmp.Name(), d.path(key))
+	}
+	if e != nil {
+		os.Remove(tmp.Name())
+		return e
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.items[key]; ok {
+		d.size -= el.Value.(*diskitem).size
+		d.ll.Remove(el)
+	}
+	d.items[key] = d.ll.PushFront(&diskitem{key: key, size: int64(len(b))})
+	d.size += int64(len(b))
+	d.evict()
+	return nil
+}
+
+// Resize changes the budget, evicting if it has to.
+func (d *DiskCache) Resize(budget int64) {
+	d.mu.Lock()
+	d.Budget = budget
+	d.evict()
+	d.mu.Unlock()
+}
+
+// Delete key
+func (d *DiskCache) Delete(key string) {
+	d.mu.Lock()
+	if el, ok := d.items[key]; ok {
+		d.remove(el)
+	}
+	d.mu.Unlock()
+}
+
+// Purge every key starting with prefix
+func (d *DiskCache) Purge(prefix string) {
+	d.mu.Lock()
+	for key, el := range d.items {
+		if strings.HasPrefix(key, prefix) {
+			d.remove(el)
+		}
+	}
+	d.mu.Unlock()
+}
+
+// Size of the cache in bytes
+func (d *DiskCache) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.size
+}
+
+// Stats returns a copy of the counters
+func (d *DiskCache) Stats() CacheStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.stats
+	s.Bytes = d.size
+	s.Items = d.ll.Len()
+	return s
+}
+
+// Remove least recently used files until we fit. Must hold mu.
+func (d *DiskCache) evict() {
+	for d.size > d.Budget && d.ll.Len() > 0 {
+		d.remove(d.ll.Back())
+		d.stats.Evictions++
+	}
+}
+
+// Must hold mu.
+func (d *DiskCache) remove(el *list.Element) {
+	it := el.Value.(*diskitem)
+	d.ll.Remove(el)
+	delete(d.items, it.key)
+	d.size -= it.size
+	if e := os.Remove(d.path(it.key)); e != nil && !os.IsNotExist(e) {
+		log.Println("Disk cache:", e)
+	}
+}
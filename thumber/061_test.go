@@ -0,0 +1,38 @@
+package thumber
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir, e := ioutil.TempDir("", "thumber")
+	assert.Nil(t, e)
+	defer os.RemoveAll(dir)
+
+	d, e := NewDiskCache(dir, 10)
+	assert.Nil(t, e)
+	assert.Nil(t, d.Set("abc123/1x1.png", []byte("1234")))
+	assert.Nil(t, d.Set("abc123/2x2.png", []byte("1234")))
+	_, ok := d.Get("abc123/1x1.png") // 2x2 is now least recently used
+	assert.True(t, ok)
+	assert.Nil(t, d.Set("xyz789/3x3.png", []byte("1234")))
+	_, ok = d.Get("abc123/2x2.png")
+	assert.False(t, ok)
+	assert.Equal(t, int64(8), d.Size())
+
+	// Survives a restart
+	d, e = NewDiskCache(dir, 10)
+	assert.Nil(t, e)
+	b, ok := d.Get("xyz789/3x3.png")
+	assert.True(t, ok)
+	assert.Equal(t, "1234", string(b))
+
+	d.Purge("abc123/")
+	_, ok = d.Get("abc123/1x1.png")
+	assert.False(t, ok)
+	assert.Equal(t, int64(4), d.Size())
+}
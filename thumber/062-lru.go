@@ -0,0 +1,145 @@
+package thumber
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemCache is an in-memory LRU cache bounded by bytes, with an optional TTL
+// per entry.
+type MemCache struct {
+	Budget int64         // max bytes of keys and values
+	TTL    time.Duration // default TTL, 0 for none
+
+	mu    sync.Mutex
+	size  int64
+	ll    *list.List // front is most recently used
+	items map[string]*list.Element
+	stats CacheStats
+}
+
+// CacheStats counts what a cache has been doing.
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Bytes     int64 `json:"bytes"`
+	Items     int   `json:"items"`
+}
+
+type memitem struct {
+	key     string
+	b       []byte
+	expires time.Time
+}
+
+func (it *memitem) size() int64 {
+	return int64(len(it.key) + len(it.b))
+}
+
+// NewMemCache returns an empty MemCache
+func NewMemCache(budget int64, ttl time.Duration) *MemCache {
+	return &MemCache{Budget: budget, TTL: ttl, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+// Get bytes for key. Expired entries are misses.
+func (c *MemCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	it := el.Value.(*memitem)
+	if !it.expires.IsZero() && time.Now().After(it.expires) {
+		c.remove(el)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	return it.b, true
+}
+
+// Set b for key with the default TTL
+func (c *MemCache) Set(key string, b []byte) {
+	c.mu.Lock()
+	ttl := c.TTL
+	c.mu.Unlock()
+	c.SetTTL(key, b, ttl)
+}
+
+// SetTTL sets b for key, expiring after ttl (0 for never), then evicts the
+// least recently used entries until the cache fits its budget.
+func (c *MemCache) SetTTL(key string, b []byte, ttl time.Duration) {
+	it := &memitem{key: key, b: b}
+	if ttl > 0 {
+		it.expires = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	if it.size() > c.Budget {
+		return
+	}
+	c.items[key] = c.ll.PushFront(it)
+	c.size += it.size()
+	for c.size > c.Budget {
+		c.remove(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Resize changes the budget and default TTL, evicting if it has to.
+func (c *MemCache) Resize(budget int64, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Budget, c.TTL = budget, ttl
+	for c.size > c.Budget && c.ll.Len() > 0 {
+		c.remove(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Delete key
+func (c *MemCache) Delete(key string) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.mu.Unlock()
+}
+
+// Purge every key starting with prefix
+func (c *MemCache) Purge(prefix string) {
+	c.mu.Lock()
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(el)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// Stats returns a copy of the counters
+func (c *MemCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Bytes = c.size
+	s.Items = c.ll.Len()
+	return s
+}
+
+// Must hold mu.
+func (c *MemCache) remove(el *list.Element) {
+	it := el.Value.(*memitem)
+	c.ll.Remove(el)
+	delete(c.items, it.key)
+	c.size -= it.size()
+}
@@ -0,0 +1,40 @@
+package thumber
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemCache(t *testing.T) {
+	c := NewMemCache(20, 0) // room for two 4 byte keys with 6 byte values
+	c.Set("key1", []byte("value1"))
+	c.Set("key2", []byte("value2"))
+	_, ok := c.Get("key1") // key2 is now least recently used
+	assert.True(t, ok)
+	c.Set("key3", []byte("value3"))
+	_, ok = c.Get("key2")
+	assert.False(t, ok)
+	b, ok := c.Get("key3")
+	assert.True(t, ok)
+	assert.Equal(t, "value3", string(b))
+
+	// Too big for the budget
+	c.Set("huge", make([]byte, 100))
+	_, ok = c.Get("huge")
+	assert.False(t, ok)
+
+	c.SetTTL("key4", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = c.Get("key4")
+	assert.False(t, ok)
+
+	c.Purge("key")
+	s := c.Stats()
+	assert.Equal(t, int64(2), s.Hits)
+	assert.Equal(t, int64(3), s.Misses)
+	assert.Equal(t, int64(2), s.Evictions) // key2 for key3, key1 for key4
+	assert.Equal(t, int64(0), s.Bytes)
+	assert.Equal(t, 0, s.Items)
+}
@@ -0,0 +1,92 @@
+package thumber
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Transform is what a resize URL asks for, whichever route it came in on.
+// /320/0/abc123.jpg, /abc123.JPEG/320/0 and an Options.CustomFormat route asking for the
+// same thing are all the same Transform, and share a cache key.
+type Transform struct {
+	ID     string
+	Width  int
+	Height int
+	Ext    string // png, jpeg or gif
+}
+
+// Read a Transform from the route variables. IDs that can't exist are errNotFound.
+func (s *Server) parseTransform(r *http.Request) (Transform, error) {
+	vars := mux.Vars(r)
+	t := Transform{ID: vars["id"], Ext: normext(vars["ext"])}
+	if len(t.ID) != s.opts.IDLength {
+		return t, errNotFound
+	}
+	var e error
+	if t.Width, e = strconv.Atoi(vars["w"]); e != nil {
+		return t, fmt.Errorf("bad width %q", vars["w"])
+	}
+	if t.Height, e = strconv.Atoi(vars["h"]); e != nil {
+		return t, fmt.Errorf("bad height %q", vars["h"])
+	}
+	return t, s.check(t)
+}
+
+// Check that t can be rendered, at most Options.MaxSize each way
+func (s *Server) check(t Transform) error {
+	return t.Check(s.opts.MaxSize)
+}
+
+// Check returns an error unless t can be rendered: a size, at most maxSize
+// each way (0 keeps the aspect ratio, but not both), and an extension we
+// encode. The server answers 400 for these.
+func (t Transform) Check(maxSize int) error {
+	switch ext := normext(t.Ext); {
+	case t.Width < 0 || t.Height < 0 || t.Width == 0 && t.Height == 0:
+		return fmt.Errorf("bad size %dx%d", t.Width, t.Height)
+	case t.Width > maxSize || t.Height > maxSize:
+		return fmt.Errorf("size %dx%d is over %d", t.Width, t.Height, maxSize)
+	case ext != "png" && ext != "jpeg" && ext != "gif":
+		return fmt.Errorf("bad extension %q", t.Ext)
+	}
+	return nil
+}
+
+// Key is the cache key for the rendered output
+func (t Transform) Key() string {
+	return t.ID + "/" + strconv.Itoa(t.Width) + "x" + strconv.Itoa(t.Height) + "." + t.Ext
+}
+
+// Cache key for an original upload. All keys for an ID start with "id/".
+func origKey(id string) string {
+	return id + "/orig"
+}
+
+// Cache key for whatever r asks for, or "" if it is not cacheable.
+func (s *Server) cacheKey(r *http.Request) string {
+	vars := mux.Vars(r)
+	if vars["w"] == "" && vars["h"] == "" {
+		if len(vars["id"]) != s.opts.IDLength {
+			return ""
+		}
+		return origKey(vars["id"])
+	}
+	t, e := s.parseTransform(r)
+	if e != nil {
+		return ""
+	}
+	return t.Key()
+}
+
+// Normalize an extension from a URL: "JPG" and "jpg" are "jpeg".
+func normext(ext string) string {
+	ext = strings.ToLower(ext)
+	if ext == "jpg" {
+		return "jpeg"
+	}
+	return ext
+}
@@ -0,0 +1,57 @@
+package thumber
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Flight runs one call per key at a time. Callers asking for a key that is
+// already in flight wait for it and share its result and error.
+type Flight struct {
+	mu    sync.Mutex
+	calls map[string]*flightcall
+
+	coalesced int64 // atomic
+}
+
+var errFlight = errors.New("call did not return")
+
+type flightcall struct {
+	wg  sync.WaitGroup
+	b   []byte
+	err error
+}
+
+// Do calls fn once for all concurrent callers with key. shared is true for
+// callers who waited on someone else's call.
+func (f *Flight) Do(key string, fn func() ([]byte, error)) (b []byte, err error, shared bool) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = map[string]*flightcall{}
+	}
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		atomic.AddInt64(&f.coalesced, 1)
+		c.wg.Wait()
+		return c.b, c.err, true
+	}
+	c := &flightcall{err: errFlight} // if fn panics
+	c.wg.Add(1)
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.b, c.err = fn()
+	return c.b, c.err, false
+}
+
+// Coalesced is how many callers shared another caller's result.
+func (f *Flight) Coalesced() int64 {
+	return atomic.LoadInt64(&f.coalesced)
+}
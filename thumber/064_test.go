@@ -0,0 +1,34 @@
+package thumber
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlight(t *testing.T) {
+	f := new(Flight)
+	var calls int32
+	var wg sync.WaitGroup
+	release := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, e, _ := f.Do("abc123/1x1.png", func() ([]byte, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return []byte("png"), nil
+			})
+			assert.Nil(t, e)
+			assert.Equal(t, "png", string(b))
+		}()
+	}
+	waitFor(t, func() bool { return f.Coalesced() == 9 }) // all waiting on the first
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls)
+	assert.Equal(t, int64(9), f.Coalesced())
+}
@@ -0,0 +1,82 @@
+package thumber
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	errBusy         = errors.New("too busy, try again later")
+	errQueueTimeout = errors.New("waited too long for a render worker")
+)
+
+// Pool is a fixed number of workers and a bounded line for them.
+type Pool struct {
+	Timeout time.Duration // longest wait in line, 0 for no limit
+
+	slots chan struct{}
+	queue chan struct{}
+}
+
+// NewPool returns a Pool with workers running at once and queue waiting.
+func NewPool(workers, queue int, timeout time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	return &Pool{Timeout: timeout, slots: make(chan struct{}, workers), queue: make(chan struct{}, queue)}
+}
+
+// Do runs fn on a free worker. If none is free it waits in line, and gives
+// up with errBusy if the line is full, errQueueTimeout after Timeout, or
+// ctx's error when it is done.
+func (p *Pool) Do(ctx context.Context, fn func() ([]byte, error)) ([]byte, error) {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		select {
+		case p.queue <- struct{}{}:
+		default:
+			return nil, errBusy
+		}
+		var timeout <-chan time.Time
+		if p.Timeout > 0 {
+			timer := time.NewTimer(p.Timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case p.slots <- struct{}{}:
+			<-p.queue
+		case <-timeout:
+			<-p.queue
+			return nil, errQueueTimeout
+		case <-ctx.Done():
+			<-p.queue
+			return nil, ctx.Err()
+		}
+	}
+	defer func() { <-p.slots }()
+	return fn()
+}
+
+// Running is how many workers are busy
+func (p *Pool) Running() int {
+	return len(p.slots)
+}
+
+// Waiting is how many are in line
+func (p *Pool) Waiting() int {
+	return len(p.queue)
+}
+
+// Retry is a guess at when to come back, for Retry-After
+func (p *Pool) Retry() time.Duration {
+	if p.Timeout <= 0 || p.Timeout > time.Minute {
+		return time.Minute
+	}
+	return p.Timeout
+}
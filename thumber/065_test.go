@@ -0,0 +1,103 @@
+package thumber
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	p := NewPool(1, 1, 50*time.Millisecond)
+	hold := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, e := p.Do(context.Background(), func() ([]byte, error) { close(started); <-hold; return nil, nil })
+		done <- e
+	}()
+	<-started
+	assert.Equal(t, 1, p.Running())
+
+	// One waits in line and gives up, then the line has room again
+	go func() {
+		_, e := p.Do(context.Background(), func() ([]byte, error) { return nil, nil })
+		done <- e
+	}()
+	waitFor(t, func() bool { return p.Waiting() == 1 })
+	_, e := p.Do(context.Background(), func() ([]byte, error) { return nil, nil })
+	assert.Equal(t, errBusy, e)
+	assert.Equal(t, errQueueTimeout, <-done)
+	assert.Equal(t, 0, p.Waiting())
+
+	// Or leaves with its request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, e = p.Do(ctx, func() ([]byte, error) { return nil, nil })
+	assert.Equal(t, context.Canceled, e)
+	assert.Equal(t, 0, p.Waiting())
+
+	// Waiters get the next free worker
+	go func() {
+		_, e := p.Do(context.Background(), func() ([]byte, error) { return []byte("ok"), nil })
+		done <- e
+	}()
+	waitFor(t, func() bool { return p.Waiting() == 1 })
+	close(hold)
+	assert.Nil(t, <-done)
+	assert.Nil(t, <-done)
+	assert.Equal(t, 0, p.Running())
+}
+
+func TestPoolBusy(t *testing.T) {
+	srv := newTestServer(t, func(o *Options) { o.Workers, o.Queue, o.QueueTimeout = 1, 0, time.Second })
+	hold := make(chan struct{})
+	go srv.pool.Do(context.Background(), func() ([]byte, error) { <-hold; return nil, nil })
+	defer close(hold)
+	waitFor(t, func() bool { return srv.pool.Running() == 1 })
+
+	up := uploadJSON(t, srv, nil)
+	// Renders are turned away, originals aren't
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/100/100/"+up["id"]+".jpg", nil))
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/"+up["id"]+".jpg", nil))
+	assert.Equal(t, 200, w.Code)
+}
+
+// Renders waiting for a worker don't hold Options.MaxUsers slots, and
+// waiting for a slot ends with the request
+func TestRenderSlots(t *testing.T) {
+	s := newTestServer(t, func(o *Options) { o.MaxUsers, o.Workers = 1, 1 })
+	b, _ := ioutil.ReadFile("testdata/one.jpeg")
+	id, _, _ := s.Upload(b, 0)
+	hold := make(chan struct{})
+	go s.pool.Do(context.Background(), func() ([]byte, error) { <-hold; return nil, nil })
+	waitFor(t, func() bool { return s.pool.Running() == 1 })
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/32/0/"+id+".jpg", nil))
+		done <- w.Code
+	}()
+	waitFor(t, func() bool { return s.pool.Waiting() == 1 })
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/"+id+".jpg", nil))
+	assert.Equal(t, 200, w.Code)
+	close(hold)
+	assert.Equal(t, 200, <-done)
+
+	s.ratelimit <- Hit{}
+	defer s.unlimit()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/"+id+".jpg", nil).WithContext(ctx))
+	assert.Equal(t, 503, w.Code)
+}
@@ -0,0 +1,108 @@
+package thumber
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Meta is what we know about an upload, stored next to it as "id.meta".
+// The deletion token is never stored, only its sha256.
+type Meta struct {
+	ID        string    `json:"id"`
+	TokenHash string    `json:"token"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	Hash      string    `json:"sha256"` // of the original, for ETags
+	Size      int64     `json:"size"`
+	Key       string    `json:"key,omitempty"` // name of the API key that uploaded it
+}
+
+// Expired returns true if the upload has a TTL and it has passed.
+func (m *Meta) Expired() bool {
+	return !m.Expires.IsZero() && time.Now().After(m.Expires)
+}
+
+// Generate a secret deletion token
+func tokengen() string {
+	b := make([]byte, 16)
+	if _, e := rand.Read(b); e != nil {
+		panic(e)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Hash a deletion token for storage
+func tokenhash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check returns true if token is the deletion token for this upload.
+func (m *Meta) Check(token string) bool {
+	if token == "" || m.TokenHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(tokenhash(token)), []byte(m.TokenHash)) == 1
+}
+
+// Write metadata for an upload
+func (s *Server) putmeta(m *Meta) error {
+	b, e := json.Marshal(m)
+	if e != nil {
+		return e
+	}
+	if e = s.store.Put(m.ID+".meta", b); e != nil {
+		return e
+	}
+	s.c1.Set(m.ID+"/meta", b)
+	return nil
+}
+
+// Read metadata for an upload, cached in c1
+func (s *Server) getmeta(id string) (*Meta, error) {
+	if b, ok := s.c1.Get(id + "/meta"); ok {
+		return parsemeta(b)
+	}
+	b, e := s.store.Get(id + ".meta")
+	if e != nil {
+		return nil, e
+	}
+	s.c1.Set(id+"/meta", b)
+	return parsemeta(b)
+}
+
+// Read metadata for an upload from storage, not cached: for passes over
+// every upload, which mustn't push thumbnails out of c1
+func (s *Server) readmeta(id string) (*Meta, error) {
+	b, e := s.store.Get(id + ".meta")
+	if e != nil {
+		return nil, e
+	}
+	return parsemeta(b)
+}
+
+func parsemeta(b []byte) (*Meta, error) {
+	m := new(Meta)
+	if e := json.Unmarshal(b, m); e != nil {
+		return nil, e
+	}
+	return m, nil
+}
+
+// Remove an upload and its metadata
+func (s *Server) remove(id string) error {
+	e := s.store.Delete(id)
+	if e != nil && !os.IsNotExist(e) {
+		return e
+	}
+	e = s.store.Delete(id + ".meta")
+	if e != nil && !os.IsNotExist(e) {
+		return e
+	}
+	return nil
+}
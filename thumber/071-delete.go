@@ -0,0 +1,117 @@
+package thumber
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Delete an upload (DELETE /{id}) with the token issued when it was uploaded.
+// The token is read from the X-Delete-Token header or the "token" parameter.
+func (s *Server) s0Delete(w http.ResponseWriter, r *http.Request) {
+	if !s.ifCachedDo(w, r) { // we dont cache here but we rate limit and log
+		return
+	}
+	defer s.unlimit()
+
+	id := mux.Vars(r)["id"]
+	token := r.Header.Get("X-Delete-Token")
+	if token == "" {
+		token = r.FormValue("token")
+	}
+	code := s.deleteUpload(id, token)
+	if code != http.StatusNoContent {
+		s.httpError(w, r, code, http.StatusText(code))
+		return
+	}
+	w.WriteHeader(code)
+}
+
+// Delete an upload from a form (POST /delete with "id" and "token").
+func (s *Server) s0DeleteForm(w http.ResponseWriter, r *http.Request) {
+	if !s.ifCachedDo(w, r) {
+		return
+	}
+	defer s.unlimit()
+
+	switch s.deleteUpload(r.FormValue("id"), r.FormValue("token")) {
+	case http.StatusNoContent:
+		http.Redirect(w, r, s.url("/?deleted"), http.StatusFound)
+	default:
+		http.Redirect(w, r, s.url("/?bad"), http.StatusFound)
+	}
+}
+
+// Delete removes an upload and its renders, if token is its deletion token.
+func (s *Server) Delete(id, token string) error {
+	switch code := s.deleteUpload(id, token); code {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return errNotFound
+	default:
+		return errors.New(strings.ToLower(http.StatusText(code)))
+	}
+}
+
+// Verify token, remove the upload and its metadata, and purge its cache.
+// Returns the HTTP status for the outcome.
+func (s *Server) deleteUpload(id, token string) int {
+	if len(id) != s.opts.IDLength {
+		return http.StatusNotFound
+	}
+	m, e := s.getmeta(id)
+	if e != nil {
+		s.debug("Delete: no metadata for", id, e)
+		return http.StatusNotFound
+	}
+	if !m.Check(token) {
+		s.logger.Println("Delete: bad token for", id)
+		return http.StatusForbidden
+	}
+	s.bury(id)
+	if e = s.remove(id); e != nil {
+		s.logger.Println("Delete:", id, e)
+		return http.StatusInternalServerError
+	}
+	s.purge(id)
+	s.unaccount(m)
+	s.logger.Println("Deleted:", id)
+	return http.StatusNoContent
+}
+
+// Remember a deletion, so renders finishing after it don't cache the upload
+// again and cached copies aren't served. Swept like expiries.
+func (s *Server) bury(id string) {
+	s.dmutex.Lock()
+	s.deleted[id] = time.Now()
+	s.dmutex.Unlock()
+}
+
+// Returns true if the upload was deleted
+func (s *Server) buried(id string) bool {
+	s.dmutex.Lock()
+	_, ok := s.deleted[id]
+	s.dmutex.Unlock()
+	return ok
+}
+
+// Cache an original or render of upload id, in c1 and, if disk, c2.
+// Not if it was deleted meanwhile: bury comes before the purge, so either
+// this sees the tombstone or the purge sees what this cached.
+func (s *Server) cache(id, key string, b []byte, disk bool) {
+	s.dmutex.Lock()
+	defer s.dmutex.Unlock()
+	if _, ok := s.deleted[id]; ok {
+		return
+	}
+	s.c1.Set(key, b)
+	if disk && s.c2 != nil {
+		if e := s.c2.Set(key, b); e != nil {
+			s.logger.Println("Disk cache:", e)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package thumber
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	tombstone = 24 * time.Hour
+	rescan    = 24 * time.Hour // how often Sweep reads all the metadata
+)
+
+// Remember when an upload expires, until it is swept and a while after
+func (s *Server) setexpiry(id string, t time.Time) {
+	s.emutex.Lock()
+	s.expiries[id] = t
+	s.pending[id] = t
+	s.emutex.Unlock()
+}
+
+// Forget an upload that was swept, or deleted before it expired
+func (s *Server) swept(id string) {
+	s.emutex.Lock()
+	delete(s.pending, id)
+	s.emutex.Unlock()
+}
+
+// The uploads Sweep should look at: every one with metadata if it hasn't
+// read them all lately, or else the ones it knows are past their TTL
+func (s *Server) sweepable() ([]string, error) {
+	s.emutex.Lock()
+	scan := time.Since(s.scanned) > rescan
+	s.emutex.Unlock()
+	var ids []string
+	if !scan {
+		now := time.Now()
+		s.emutex.Lock()
+		for id, t := range s.pending {
+			if now.After(t) {
+				ids = append(ids, id)
+			}
+		}
+		s.emutex.Unlock()
+		return ids, nil
+	}
+	keys, e := s.store.List("")
+	if e != nil {
+		return nil, e
+	}
+	for _, key := range keys {
+		if strings.HasSuffix(key, ".meta") {
+			ids = append(ids, strings.TrimSuffix(key, ".meta"))
+		}
+	}
+	s.emutex.Lock()
+	s.scanned = time.Now()
+	s.emutex.Unlock()
+	return ids, nil
+}
+
+// Returns true if the upload has expired, by its metadata m (nil if it has
+// none) or by what the sweeper remembers after deleting it. The metadata
+// catches uploads this process never swept: after a restart, or another
+// instance's.
+func (s *Server) gone(id string, m *Meta) bool {
+	if m != nil && m.Expired() {
+		return true
+	}
+	s.emutex.Lock()
+	t, ok := s.expiries[id]
+	s.emutex.Unlock()
+	return ok && time.Now().After(t)
+}
+
+// Delete expired uploads and their cache at startup, then every Options.Sweep
+func (s *Server) sweeper() {
+	for s.opts.Sweep >= 0 {
+		if _, e := s.Sweep(); e != nil {
+			s.logger.Println("Sweeper:", e)
+		}
+		if s.opts.Sweep == 0 || !s.sleep(s.opts.Sweep) {
+			return
+		}
+	}
+}
+
+// Sweep deletes expired uploads now, and returns how many it deleted. The
+// first Sweep, and one a day after, reads the metadata of every upload, to
+// find the ones other instances made. The rest only read the uploads this
+// Server knows are past their TTL.
+func (s *Server) Sweep() (int, error) {
+	ids, e := s.sweepable()
+	if e != nil {
+		return 0, e
+	}
+	var n int
+	for _, id := range ids {
+		m, e := s.readmeta(id)
+		if os.IsNotExist(e) {
+			s.swept(id) // deleted
+			continue
+		}
+		if e != nil {
+			s.logger.Println("Sweeper:", id, e)
+			continue
+		}
+		if m.Expires.IsZero() {
+			s.swept(id)
+			continue
+		}
+		s.setexpiry(m.ID, m.Expires)
+		if !m.Expired() {
+			continue
+		}
+		if e = s.remove(m.ID); e != nil {
+			s.logger.Println("Sweeper:", m.ID, e)
+			continue
+		}
+		s.purge(m.ID)
+		s.unaccount(m)
+		s.swept(m.ID)
+		n++
+	}
+
+	// Forget old tombstones
+	s.emutex.Lock()
+	for id, t := range s.expiries {
+		if time.Since(t) > tombstone {
+			delete(s.expiries, id)
+		}
+	}
+	s.emutex.Unlock()
+	s.dmutex.Lock()
+	for id, t := range s.deleted {
+		if time.Since(t) > tombstone {
+			delete(s.deleted, id)
+		}
+	}
+	s.dmutex.Unlock()
+	if n > 0 || s.opts.Debug {
+		s.logger.Println("Sweeper: expired", n)
+	}
+	return n, nil
+}
@@ -0,0 +1,54 @@
+package thumber
+
+import "strings"
+
+// Stats is what is stored, from one pass over storage.
+type Stats struct {
+	Uploads  int            `json:"uploads"`
+	Bytes    int64          `json:"bytes"`    // of the originals
+	Expiring int            `json:"expiring"` // uploads with a TTL
+	Expired  int            `json:"expired"`  // past their TTL, waiting for Sweep
+	Keys     map[string]int `json:"keys"`     // uploads by API key name
+	Memory   CacheStats     `json:"memory"`
+	Disk     *CacheStats    `json:"disk,omitempty"` // nil without a disk cache
+}
+
+// Stats counts the uploads in storage.
+func (s *Server) Stats() (Stats, error) {
+	st := Stats{Keys: map[string]int{}, Memory: s.c1.Stats()}
+	if s.c2 != nil {
+		disk := s.c2.Stats()
+		st.Disk = &disk
+	}
+	keys, e := s.store.List("")
+	if e != nil {
+		return st, e
+	}
+	for _, key := range keys {
+		if strings.HasSuffix(key, ".meta") {
+			continue
+		}
+		info, e := s.store.Stat(key)
+		if e != nil {
+			continue // deleted since List
+		}
+		st.Uploads++
+		st.Bytes += info.Size
+
+		// Uploads from before metadata have no TTL or key
+		m, e := s.readmeta(key)
+		if e != nil {
+			continue
+		}
+		if !m.Expires.IsZero() {
+			st.Expiring++
+		}
+		if m.Expired() {
+			st.Expired++
+		}
+		if m.Key != "" {
+			st.Keys[m.Key]++
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,297 @@
+package thumber
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is where uploads and their metadata live. Keys are flat names
+// like "abc123" or "abc123.meta". A missing key is an os.ErrNotExist.
+type Storage interface {
+	Put(key string, b []byte) error
+	Get(key string) ([]byte, error)
+	Stat(key string) (Info, error)
+	Delete(key string) error
+	List(prefix string) ([]string, error)
+	Check() error // can we write? for /readyz
+}
+
+// Info about a stored key
+type Info struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// FileStorage keeps uploads in a directory. With Depth > 0 files are sharded
+// into subdirectories named after pairs of ID characters, so with Depth 2
+// "abc123" lives at Dir/ab/c1/abc123. Files from a flat directory are still
+// found until Migrate moves them.
+type FileStorage struct {
+	Dir   string
+	Depth int
+	Perm  os.FileMode
+}
+
+// NewFileStorage creates dir if needed and makes sure we can write to it.
+func NewFileStorage(dir string, depth int, perm os.FileMode) (*FileStorage, error) {
+	if e := os.MkdirAll(dir, perm); e != nil {
+		return nil, e
+	}
+	f := &FileStorage{Dir: dir, Depth: depth, Perm: perm}
+	if e := f.Check(); e != nil {
+		return nil, e
+	}
+	return f, nil
+}
+
+// Check that we can write to Dir
+func (f *FileStorage) Check() error {
+	tmp, e := ioutil.TempFile(f.Dir, ".boot-")
+	if e != nil {
+		return e
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// Where key should live
+func (f *FileStorage) path(key string) (string, error) {
+	if key == "" || filepath.Base(key) != key || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("bad key %q", key)
+	}
+	return filepath.Join(f.Dir, f.shard(key), key), nil
+}
+
+// Shard directories for key, like "ab/c1". Only the ID part is used,
+// so "abc123.meta" lands next to "abc123".
+func (f *FileStorage) shard(key string) string {
+	id := strings.SplitN(key, ".", 2)[0]
+	var parts []string
+	for i := 0; i < f.Depth && 2*i+2 <= len(id); i++ {
+		parts = append(parts, id[2*i:2*i+2])
+	}
+	return filepath.Join(parts...)
+}
+
+// Where key is now: sharded, flat (not migrated yet), or sharded again
+// in case Migrate moved it in between.
+func (f *FileStorage) find(key string) (string, os.FileInfo, error) {
+	p, e := f.path(key)
+	if e != nil {
+		return "", nil, e
+	}
+	fi, e := os.Stat(p)
+	if e == nil || f.Depth == 0 || !os.IsNotExist(e) {
+		return p, fi, e
+	}
+	flat := filepath.Join(f.Dir, key)
+	if fi, e := os.Stat(flat); e == nil {
+		return flat, fi, nil
+	}
+	fi, e = os.Stat(p)
+	return p, fi, e
+}
+
+// Put writes to a temporary file and renames it, so readers never see half a file.
+func (f *FileStorage) Put(key string, b []byte) error {
+	p, e := f.path(key)
+	if e != nil {
+		return e
+	}
+	if e = os.MkdirAll(filepath.Dir(p), f.Perm); e != nil {
+		return e
+	}
+	tmp, e := ioutil.TempFile(f.Dir, ".tmp-")
+	if e != nil {
+		return e
+	}
+	if _, e = tmp.Write(b); e != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return e
+	}
+	if e = tmp.Close(); e != nil {
+		os.Remove(tmp.Name())
+		return e
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// Get reads a file
+func (f *FileStorage) Get(key string) ([]byte, error) {
+	p, _, e := f.find(key)
+	if e != nil {
+		return nil, e
+	}
+	b, e := ioutil.ReadFile(p)
+	if os.IsNotExist(e) && f.Depth > 0 {
+		// moved by Migrate after find
+		if p, _, e = f.find(key); e == nil {
+			return ioutil.ReadFile(p)
+		}
+	}
+	return b, e
+}
+
+// Stat a file
+func (f *FileStorage) Stat(key string) (Info, error) {
+	_, fi, e := f.find(key)
+	if e != nil {
+		return Info{}, e
+	}
+	return Info{Key: key, Size: fi.Size(), Modified: fi.ModTime()}, nil
+}
+
+// Delete a file
+func (f *FileStorage) Delete(key string) error {
+	p, _, e := f.find(key)
+	if e != nil {
+		return e
+	}
+	return os.Remove(p)
+}
+
+// List files starting with prefix, in every shard
+func (f *FileStorage) List(prefix string) ([]string, error) {
+	var keys []string
+	e := filepath.Walk(f.Dir, func(p string, fi os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		name := fi.Name()
+		if fi.IsDir() {
+			if p != f.Dir && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(name, ".") && strings.HasPrefix(name, prefix) {
+			keys = append(keys, name)
+		}
+		return nil
+	})
+	return keys, e
+}
+
+// Migrate moves files from the top of a flat directory into their shards,
+// one rename at a time. A server using the same directory and Depth keeps
+// working while this runs. Returns the number of files moved.
+func (f *FileStorage) Migrate() (int, error) {
+	if f.Depth == 0 {
+		return 0, errors.New("nothing to migrate to, shard depth is 0")
+	}
+	files, e := ioutil.ReadDir(f.Dir)
+	if e != nil {
+		return 0, e
+	}
+	var n int
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		p, e := f.path(name)
+		if e != nil || p == filepath.Join(f.Dir, name) {
+			continue // too short to shard
+		}
+		if e = os.MkdirAll(filepath.Dir(p), f.Perm); e != nil {
+			return n, e
+		}
+		if _, e = os.Stat(p); e == nil {
+			continue // already there, leave both for a human
+		}
+		if e = os.Rename(filepath.Join(f.Dir, name), p); e != nil {
+			return n, e
+		}
+		n++
+	}
+	return n, nil
+}
+
+// MemStorage keeps uploads in memory. Good for tests.
+type MemStorage struct {
+	mu    sync.RWMutex
+	files map[string]memfile
+}
+
+type memfile struct {
+	b        []byte
+	modified time.Time
+}
+
+// NewMemStorage returns an empty MemStorage
+func NewMemStorage() *MemStorage {
+	return &MemStorage{files: map[string]memfile{}}
+}
+
+// Put copies b into memory
+func (m *MemStorage) Put(key string, b []byte) error {
+	c := make([]byte, len(b))
+	copy(c, b)
+	m.mu.Lock()
+	m.files[key] = memfile{b: c, modified: time.Now()}
+	m.mu.Unlock()
+	return nil
+}
+
+// Get a copy of the stored bytes
+func (m *MemStorage) Get(key string) ([]byte, error) {
+	m.mu.RLock()
+	f, ok := m.files[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	c := make([]byte, len(f.b))
+	copy(c, f.b)
+	return c, nil
+}
+
+// Stat a key
+func (m *MemStorage) Stat(key string) (Info, error) {
+	m.mu.RLock()
+	f, ok := m.files[key]
+	m.mu.RUnlock()
+	if !ok {
+		return Info{}, os.ErrNotExist
+	}
+	return Info{Key: key, Size: int64(len(f.b)), Modified: f.modified}, nil
+}
+
+// Delete a key
+func (m *MemStorage) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[key]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m.files, key)
+	return nil
+}
+
+// Check is always ok, memory is writable
+func (m *MemStorage) Check() error {
+	return nil
+}
+
+// List keys starting with prefix, sorted
+func (m *MemStorage) List(prefix string) ([]string, error) {
+	m.mu.RLock()
+	var keys []string
+	for key := range m.files {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,249 @@
+package thumber
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps uploads in an S3 compatible bucket (AWS, MinIO, ...).
+// Requests use path-style URLs and are signed with AWS Signature V4.
+type S3Storage struct {
+	Endpoint  string // like http://localhost:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+// NewS3Storage returns an S3Storage for bucket at endpoint.
+func NewS3Storage(endpoint, bucket, region, accessKey, secretKey string) (*S3Storage, error) {
+	if endpoint == "" || bucket == "" {
+		return nil, errors.New("s3 storage needs -s3-endpoint and -s3-bucket")
+	}
+	if _, e := url.Parse(endpoint); e != nil {
+		return nil, e
+	}
+	return &S3Storage{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Bucket:    bucket,
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// Put an object
+func (s *S3Storage) Put(key string, b []byte) error {
+	resp, e := s.do("PUT", key, nil, b)
+	if e != nil {
+		return e
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get an object
+func (s *S3Storage) Get(key string) ([]byte, error) {
+	resp, e := s.do("GET", key, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Stat an object with HEAD
+func (s *S3Storage) Stat(key string) (Info, error) {
+	resp, e := s.do("HEAD", key, nil, nil)
+	if e != nil {
+		return Info{}, e
+	}
+	resp.Body.Close()
+	info := Info{Key: key, Size: resp.ContentLength}
+	info.Modified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// Delete an object
+func (s *S3Storage) Delete(key string) error {
+	resp, e := s.do("DELETE", key, nil, nil)
+	if e != nil {
+		return e
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Check that we can write to the bucket
+func (s *S3Storage) Check() error {
+	if e := s.Put(".readyz", nil); e != nil {
+		return e
+	}
+	return s.Delete(".readyz")
+}
+
+type s3list struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List object keys starting with prefix (ListObjectsV2)
+func (s *S3Storage) List(prefix string) ([]string, error) {
+	var keys []string
+	var next string
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if next != "" {
+			q.Set("continuation-token", next)
+		}
+		resp, e := s.do("GET", "", q, nil)
+		if e != nil {
+			return nil, e
+		}
+		var l s3list
+		e = xml.NewDecoder(resp.Body).Decode(&l)
+		resp.Body.Close()
+		if e != nil {
+			return nil, e
+		}
+		for _, c := range l.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !l.IsTruncated || l.NextContinuationToken == "" {
+			return keys, nil
+		}
+		next = l.NextContinuationToken
+	}
+}
+
+// Sign and send a request. Non-2xx responses are errors, 404 is os.ErrNotExist.
+func (s *S3Storage) do(method, key string, q url.Values, body []byte) (*http.Response, error) {
+	path := "/" + s.Bucket + "/"
+	if key != "" {
+		path += key
+	}
+	u, e := url.Parse(s.Endpoint)
+	if e != nil {
+		return nil, e
+	}
+	u.Path = path
+	u.RawPath = s3escape(path, true)
+	u.RawQuery = s3query(q)
+	req, e := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if e != nil {
+		return nil, e
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, body, time.Now().UTC())
+
+	resp, e := s.Client.Do(req)
+	if e != nil {
+		return nil, e
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3: %s %s: %s %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// Add AWS Signature V4 headers to req
+func (s *S3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	amzdate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := sha256hex(body)
+	req.Header.Set("X-Amz-Date", amzdate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.AccessKey == "" {
+		return // anonymous
+	}
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payload,
+		"x-amz-date:" + amzdate,
+		"",
+		signed,
+		payload,
+	}, "\n")
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	tosign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + sha256hex([]byte(canonical))
+
+	key := hmacsha256([]byte("AWS4"+s.SecretKey), day)
+	key = hmacsha256(key, s.Region)
+	key = hmacsha256(key, "s3")
+	key = hmacsha256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacsha256(key, tosign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func sha256hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacsha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Escape the way SigV4 wants: everything but A-Za-z0-9-_.~ (and maybe '/')
+func s3escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', slash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Canonical (sorted, escaped) query string
+func s3query(q url.Values) string {
+	var keys []string
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3escape(k, false)+"="+s3escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
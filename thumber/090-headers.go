@@ -0,0 +1,96 @@
+package thumber
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var maxAge = regexp.MustCompile(`max-age=([0-9]+)`)
+
+// Set Cache-Control, Expires, ETag and Last-Modified for an image response.
+// The ETag is the sha256 of the original, kept in its metadata, plus the
+// transform, so it is known before anything is read or rendered. Returns true if
+// the client's copy is still good and a 304 was sent. m is the upload's
+// metadata, nil if it has none.
+func (s *Server) cacheHeaders(w http.ResponseWriter, r *http.Request, m *Meta, id, key string) bool {
+	policy := s.opts.CacheThumbs
+	if key == origKey(id) {
+		policy = s.opts.CacheOriginals
+	}
+	h := w.Header()
+
+	// Expiring uploads can't be cached past their expiry
+	if m != nil && !m.Expires.IsZero() {
+		left := int64(time.Until(m.Expires) / time.Second)
+		if left < 0 {
+			left = 0
+		}
+		if age := maxAge.FindStringSubmatch(policy); age != nil {
+			if n, _ := strconv.ParseInt(age[1], 10, 64); n < left {
+				left = n
+			}
+		}
+		policy = maxAge.ReplaceAllString(strings.Replace(policy, ", immutable", "", 1),
+			"max-age="+strconv.FormatInt(left, 10))
+	}
+	h.Set("Cache-Control", policy)
+	if age := maxAge.FindStringSubmatch(policy); age != nil {
+		n, _ := strconv.Atoi(age[1])
+		h.Set("Expires", time.Now().Add(time.Duration(n)*time.Second).UTC().Format(http.TimeFormat))
+	}
+
+	// Uploads from before metadata had hashes get no validators
+	if m == nil || m.Hash == "" {
+		return false
+	}
+	etag := `"` + m.Hash[:16] + "-" + strings.TrimPrefix(key, id+"/") + `"`
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", m.Created.UTC().Format(http.TimeFormat))
+	if notModified(r, etag, m.Created) {
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// Returns true if the request's If-None-Match or If-Modified-Since says
+// the client already has this version (RFC 7232 section 6).
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	ims, e := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if e != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(ims)
+}
+
+// Write image bytes with the type they really are. Anything that doesn't
+// sniff as an image is served as application/octet-stream, and nosniff keeps
+// browsers from guessing otherwise. Handles HEAD and Range.
+func serveImage(w http.ResponseWriter, r *http.Request, b []byte) {
+	ctype := http.DetectContentType(b)
+	if !strings.HasPrefix(ctype, "image/") {
+		ctype = "application/octet-stream"
+	}
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+}
@@ -0,0 +1,80 @@
+package thumber
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNotFound = errors.New("image not found")
+	errDecode   = errors.New("not an image")
+)
+
+// Send an error the client can use: the placeholder image for <img> tags,
+// JSON for API clients, and HTML for everyone else.
+func (s *Server) httpError(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	h := w.Header()
+	for _, k := range []string{"ETag", "Last-Modified", "Expires"} {
+		h.Del(k)
+	}
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	switch {
+	case s.opts.Placeholder != nil && wantsImage(r):
+		h.Set("Content-Type", http.DetectContentType(s.opts.Placeholder))
+		h.Set("Content-Length", strconv.Itoa(len(s.opts.Placeholder)))
+		w.WriteHeader(code)
+		if r.Method != "HEAD" {
+			w.Write(s.opts.Placeholder)
+		}
+	case strings.Contains(r.Header.Get("Accept"), "application/json"):
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": code, "error": msg})
+	default:
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(code)
+		w.Write([]byte(s.header() + "<h1>" + strconv.Itoa(code) + " " + http.StatusText(code) + "</h1>\n<p>" +
+			html.EscapeString(msg) + "</p>\n<a href=\"" + html.EscapeString(s.url("/")) + "\">Thumber</a>\n" + footer))
+	}
+}
+
+// Returns true if e is from a request's context ending, usually the client leaving
+func canceled(e error) bool {
+	return e == context.Canceled || e == context.DeadlineExceeded
+}
+
+// Returns true if the request is from an <img> tag (or looks like it).
+func wantsImage(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Dest") == "image" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.HasPrefix(accept, "image/") && !strings.Contains(accept, "text/html")
+}
+
+// Send the error for a failed image read or render.
+func (s *Server) imageError(w http.ResponseWriter, r *http.Request, e error) {
+	switch {
+	case e == errNotFound || os.IsNotExist(e):
+		s.httpError(w, r, http.StatusNotFound, errNotFound.Error())
+	case e == errDecode:
+		s.httpError(w, r, http.StatusUnprocessableEntity, e.Error())
+	case canceled(e):
+		s.httpError(w, r, http.StatusServiceUnavailable, "request canceled")
+	case e == errBusy || e == errQueueTimeout:
+		s.logger.Println("Not rendering:", e)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds(s.pool.Retry())))
+		s.httpError(w, r, http.StatusServiceUnavailable, errBusy.Error())
+	default:
+		s.logger.Println(e)
+		s.httpError(w, r, http.StatusInternalServerError, "internal error")
+	}
+}
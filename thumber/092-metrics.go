@@ -0,0 +1,242 @@
+package thumber
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Metrics, served at /metrics (or by MetricsHandler) in the Prometheus text format.
+type metrics struct {
+	httpRequests, httpLatency, renderStages *Metric
+	rateLimited, uploadCount, uploadBytes   *Metric
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		httpRequests: newMetric("counter", "thumber_requests_total", "HTTP requests by route and status.", "route", "code"),
+		httpLatency:  newMetric("histogram", "thumber_request_duration_seconds", "HTTP request latency by route and status.", "route", "code"),
+		renderStages: newMetric("histogram", "thumber_render_seconds", "Time spent decoding, resizing and encoding images, by format.", "stage", "format"),
+		rateLimited:  newMetric("counter", "thumber_rate_limited_total", "Requests refused by the rate limiter."),
+		uploadCount:  newMetric("counter", "thumber_uploads_total", "Uploads stored."),
+		uploadBytes:  newMetric("counter", "thumber_upload_bytes_total", "Bytes of uploads stored."),
+	}
+}
+
+// Latency buckets, in seconds
+var buckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metric is a counter or histogram, with a series per set of label values.
+type Metric struct {
+	Kind, Name, Help string
+	Labels           []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	sum    float64
+	count  uint64
+	bins   []uint64 // histograms, one per bucket
+}
+
+func newMetric(kind, name, help string, labels ...string) *Metric {
+	return &Metric{Kind: kind, Name: name, Help: help, Labels: labels, series: map[string]*series{}}
+}
+
+func (m *Metric) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s := m.series[key]
+	if s == nil {
+		s = &series{values: values}
+		if m.Kind == "histogram" {
+			s.bins = make([]uint64, len(buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Add v to a counter
+func (m *Metric) Add(v float64, values ...string) {
+	m.mu.Lock()
+	s := m.get(values)
+	s.sum += v
+	s.count++
+	m.mu.Unlock()
+}
+
+// Observe v in a histogram
+func (m *Metric) Observe(v float64, values ...string) {
+	m.mu.Lock()
+	s := m.get(values)
+	s.sum += v
+	s.count++
+	for i, le := range buckets {
+		if v <= le {
+			s.bins[i]++
+		}
+	}
+	m.mu.Unlock()
+}
+
+// Since observes the seconds since t0. A nil Metric ignores it.
+func (m *Metric) Since(t0 time.Time, values ...string) {
+	if m == nil {
+		return
+	}
+	m.Observe(time.Since(t0).Seconds(), values...)
+}
+
+// Write the metric in the text format
+func (m *Metric) Write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Kind)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.Kind == "counter" {
+			fmt.Fprintf(w, "%s%s %s\n", m.Name, labels(m.Labels, s.values), num(s.sum))
+			continue
+		}
+		names := append(m.Labels[:len(m.Labels):len(m.Labels)], "le")
+		values := append(s.values[:len(s.values):len(s.values)], "")
+		for i, le := range buckets {
+			values[len(values)-1] = num(le)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.Name, labels(names, values), s.bins[i])
+		}
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.Name, labels(names, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.Name, labels(m.Labels, s.values), num(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.Name, labels(m.Labels, s.values), s.count)
+	}
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// {a="1",b="2"}, or nothing without labels
+func labels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func num(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// A gauge or counter read when scraped
+func writeValue(w io.Writer, kind, name, help string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", name, key, num(values[key]))
+	}
+}
+
+// MetricsHandler serves s's metrics, for mounting somewhere other than
+// /metrics, like an admin port.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(s.s0Metrics)
+}
+
+// Serve every metric
+func (s *Server) s0Metrics(w http.ResponseWriter, r *http.Request) {
+	m := s.metrics
+	var b bytes.Buffer
+	for _, m := range []*Metric{m.httpRequests, m.httpLatency, m.renderStages, m.rateLimited, m.uploadCount, m.uploadBytes} {
+		m.Write(&b)
+	}
+
+	// Caches
+	stats := map[string]CacheStats{"memory": s.c1.Stats()}
+	if s.c2 != nil {
+		stats["disk"] = s.c2.Stats()
+	}
+	hits, misses, evictions, size, items := map[string]float64{}, map[string]float64{}, map[string]float64{}, map[string]float64{}, map[string]float64{}
+	for name, st := range stats {
+		l := labels([]string{"cache"}, []string{name})
+		hits[l], misses[l], evictions[l] = float64(st.Hits), float64(st.Misses), float64(st.Evictions)
+		size[l], items[l] = float64(st.Bytes), float64(st.Items)
+	}
+	writeValue(&b, "counter", "thumber_cache_hits_total", "Cache hits.", hits)
+	writeValue(&b, "counter", "thumber_cache_misses_total", "Cache misses.", misses)
+	writeValue(&b, "counter", "thumber_cache_evictions_total", "Entries evicted to stay in budget.", evictions)
+	writeValue(&b, "gauge", "thumber_cache_bytes", "Bytes cached.", size)
+	writeValue(&b, "gauge", "thumber_cache_items", "Entries cached.", items)
+
+	// Renders
+	writeValue(&b, "gauge", "thumber_render_queue_depth", "Renders waiting for a worker.", map[string]float64{"": float64(s.pool.Waiting())})
+	writeValue(&b, "gauge", "thumber_render_workers_busy", "Renders running.", map[string]float64{"": float64(s.pool.Running())})
+	writeValue(&b, "counter", "thumber_renders_coalesced_total", "Requests that shared another request's render.", map[string]float64{"": float64(s.renders.Coalesced())})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(b.Bytes())
+}
+
+// statusWriter remembers the status code and counts the body
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+	size int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, e := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, e
+}
+
+// Count and time requests by route name and status
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t0 := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		route := "none"
+		if cur := mux.CurrentRoute(r); cur != nil && cur.GetName() != "" {
+			route = cur.GetName()
+		}
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		code := strconv.Itoa(sw.code)
+		s.metrics.httpRequests.Add(1, route, code)
+		s.metrics.httpLatency.Since(t0, route, code)
+	})
+}
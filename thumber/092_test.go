@@ -0,0 +1,58 @@
+package thumber
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetric(t *testing.T) {
+	m := newMetric("histogram", "test_seconds", "Test.", "route")
+	m.Observe(0.03, "resize")
+	m.Observe(20, "resize")
+	var b bytes.Buffer
+	m.Write(&b)
+	for _, line := range []string{
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{route="resize",le="0.025"} 0`,
+		`test_seconds_bucket{route="resize",le="0.05"} 1`,
+		`test_seconds_bucket{route="resize",le="+Inf"} 2`,
+		`test_seconds_sum{route="resize"} 20.03`,
+		`test_seconds_count{route="resize"} 2`,
+	} {
+		assert.Contains(t, b.String(), line+"\n")
+	}
+
+	c := newMetric("counter", "test_total", "Test.", "q")
+	c.Add(2, `say "hi"`)
+	b.Reset()
+	c.Write(&b)
+	assert.Contains(t, b.String(), `test_total{q="say \"hi\""} 2`+"\n")
+}
+
+func TestMetrics(t *testing.T) {
+	srv := newTestServer(t, nil)
+	up := uploadJSON(t, srv, nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/10/10/"+up["id"]+".png", nil))
+	assert.Equal(t, 200, w.Code)
+
+	w = httptest.NewRecorder()
+	srv.s0Metrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	for _, s := range []string{
+		`thumber_requests_total{route="upload",code="200"}`,
+		`thumber_request_duration_seconds_count{route="resize",code="200"}`,
+		`thumber_render_seconds_count{stage="decode",format="jpeg"}`,
+		`thumber_render_seconds_count{stage="encode",format="png"}`,
+		`thumber_cache_misses_total{cache="memory"}`,
+		"thumber_uploads_total ",
+		"thumber_render_queue_depth 0",
+	} {
+		assert.Contains(t, body, s)
+	}
+}
@@ -0,0 +1,59 @@
+package thumber
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	rtdebug "runtime/debug"
+	"sync/atomic"
+)
+
+// Paths that aren't rate limited or access logged
+var quiet = map[string]bool{"/healthz": true, "/readyz": true, "/version": true}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// The process is up
+func (s *Server) s0Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// We can take traffic: storage is writable, the cache is up and we aren't stopping.
+func (s *Server) s0Readyz(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	switch {
+	case atomic.LoadInt32(&s.stopping) == 1:
+		status = "shutting down"
+	case s.c1 == nil || s.pool == nil:
+		status = "starting"
+	case s.store == nil:
+		status = "no storage"
+	default:
+		if e := s.store.Check(); e != nil {
+			status = "storage: " + e.Error()
+		}
+	}
+	if status != "ok" {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": status})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": status})
+}
+
+// Build info
+func (s *Server) s0Version(w http.ResponseWriter, r *http.Request) {
+	rev := s.opts.Commit
+	if info, ok := rtdebug.ReadBuildInfo(); ok && rev == "" {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				rev = s.Value
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"version": s.opts.Version, "commit": rev, "go": runtime.Version()})
+}
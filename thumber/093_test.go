@@ -0,0 +1,37 @@
+package thumber
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	// Not rate limited
+	srv := newTestServer(t, func(o *Options) { o.Rate, o.Burst = 0, 0 })
+
+	get := func(path string) (int, map[string]string) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var v map[string]string
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &v))
+		return w.Code, v
+	}
+	code, _ := get("/healthz")
+	assert.Equal(t, 200, code)
+	code, v := get("/version")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, srv.opts.Version, v["version"])
+	assert.Equal(t, runtime.Version(), v["go"])
+
+	code, v = get("/readyz")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "ok", v["status"])
+	srv.Drain()
+	code, v = get("/readyz")
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "shutting down", v["status"])
+}
@@ -1,4 +1,19 @@
-package main
+package thumber
+
+import (
+	"html"
+	"strings"
+)
+
+// Page header, titled with Options.Version
+func (s *Server) header() string {
+	return strings.Replace(header, "{{version}}", html.EscapeString(s.opts.Version), 1)
+}
+
+// Upload form, posting to Options.BasePath
+func (s *Server) form() string {
+	return strings.Replace(form, "{{base}}", html.EscapeString(s.opts.BasePath), 1)
+}
 
 var header = `<!DOCTYPE html>
 <html>
@@ -6,9 +21,8 @@ var header = `<!DOCTYPE html>
 	<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
 	<meta name="viewport" content="width=device-width">
 	<meta name="theme-color" content="#375EAB">
-	<title>` + version + `</title>
-` +
-	`<style>
+	<title>{{version}}</title>
+<style>
 body{
   color: green;
   background-color:   #E0EBF5;
@@ -32,7 +46,7 @@ var form = `
 <h1>Thumber</h1>
 <h2>Thumbnail Server</h2>
 <h3> Upload a file </h3>
-<form id="post" action="/upload" enctype="multipart/form-data" method="POST">
+<form id="post" action="{{base}}/upload" enctype="multipart/form-data" method="POST">
 		<input name="file" type="file" required/></input>
     <br><input id="upload-submit" type="submit" value="upload" />
 </form>
@@ -43,6 +57,8 @@ Original Size: /fileID
 Resize: /width/height/fileID
 Resize: /fileID/width/height (alt)
 Upload: POST /upload
+Expire: POST /upload expires=24h
+Delete: DELETE /fileID (X-Delete-Token)
 
 Example: /640/480/cat.jpeg
 